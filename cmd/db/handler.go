@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/roman-mazur/architecture-practice-4-template/datastore"
+)
+
+// Record is the JSON representation of a stored key.
+type Record struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// Operation is a single write of a batch posted to /db.
+type Operation struct {
+	Key    string  `json:"key"`
+	Value  *string `json:"value,omitempty"`
+	Delete bool    `json:"delete,omitempty"`
+}
+
+// newHandler creates the HTTP API of the database:
+// GET, POST and DELETE requests to /db/{key}, batches posted to /db and
+// backups of the live data from GET /backup.
+// ETag of a key holds its version, writes with If-Match are applied only
+// to the matching version.
+func newHandler(db *datastore.Db, logger *slog.Logger) http.Handler {
+	h := http.NewServeMux()
+
+	h.HandleFunc("GET /db/{key}", func(rw http.ResponseWriter, r *http.Request) {
+		key := r.PathValue("key")
+		value, version, err := db.GetWithVersion(key)
+		if err != nil {
+			writeError(rw, logger, err)
+			return
+		}
+		rw.Header().Set("etag", etag(version))
+		writeJSON(rw, http.StatusOK, Record{Key: key, Value: value})
+	})
+
+	h.HandleFunc("POST /db/{key}", func(rw http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Value *string `json:"value"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Value == nil {
+			http.Error(rw, "request body must be a JSON object with a string value", http.StatusBadRequest)
+			return
+		}
+		version, conditional, err := ifMatch(r)
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if conditional {
+			err = db.PutIf(r.PathValue("key"), *body.Value, version)
+		} else {
+			err = db.Put(r.PathValue("key"), *body.Value)
+		}
+		if err != nil {
+			writeError(rw, logger, err)
+			return
+		}
+		rw.WriteHeader(http.StatusNoContent)
+	})
+
+	h.HandleFunc("DELETE /db/{key}", func(rw http.ResponseWriter, r *http.Request) {
+		version, conditional, err := ifMatch(r)
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if conditional {
+			err = db.DeleteIf(r.PathValue("key"), version)
+		} else {
+			err = db.Delete(r.PathValue("key"))
+		}
+		if err != nil {
+			writeError(rw, logger, err)
+			return
+		}
+		rw.WriteHeader(http.StatusNoContent)
+	})
+
+	h.HandleFunc("POST /db", func(rw http.ResponseWriter, r *http.Request) {
+		var operations []Operation
+		if err := json.NewDecoder(r.Body).Decode(&operations); err != nil {
+			http.Error(rw, "request body must be a JSON array of operations", http.StatusBadRequest)
+			return
+		}
+		var b datastore.Batch
+		for _, op := range operations {
+			switch {
+			case op.Key == "" || op.Delete == (op.Value != nil):
+				http.Error(rw, "every operation needs a key and either a value or delete", http.StatusBadRequest)
+				return
+			case op.Delete:
+				b.Delete(op.Key)
+			default:
+				b.Put(op.Key, *op.Value)
+			}
+		}
+		if err := db.Write(&b); err != nil {
+			writeError(rw, logger, err)
+			return
+		}
+		rw.WriteHeader(http.StatusNoContent)
+	})
+
+	h.HandleFunc("GET /backup", func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("content-type", "application/octet-stream")
+		// Large databases take longer than the server write timeout
+		_ = http.NewResponseController(rw).SetWriteDeadline(time.Time{})
+		if err := db.Backup(rw); err != nil {
+			// The status is already sent, break the connection so the client does not
+			// take the stream for a complete one
+			logger.Error("Backup failed", "err", err)
+			panic(http.ErrAbortHandler)
+		}
+	})
+
+	return h
+}
+
+func etag(version uint64) string {
+	return `"` + strconv.FormatUint(version, 10) + `"`
+}
+
+// ifMatch returns the version expected by the If-Match header if it is set.
+func ifMatch(r *http.Request) (uint64, bool, error) {
+	header := r.Header.Get("if-match")
+	if header == "" {
+		return 0, false, nil
+	}
+	version, err := strconv.ParseUint(strings.Trim(header, `"`), 10, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("bad If-Match header %q", header)
+	}
+	return version, true, nil
+}
+
+func writeJSON(rw http.ResponseWriter, status int, v any) {
+	rw.Header().Set("content-type", "application/json")
+	rw.WriteHeader(status)
+	_ = json.NewEncoder(rw).Encode(v)
+}
+
+// writeError maps datastore errors to HTTP statuses.
+func writeError(rw http.ResponseWriter, logger *slog.Logger, err error) {
+	switch {
+	case errors.Is(err, datastore.ErrNotFound):
+		http.Error(rw, "key not found", http.StatusNotFound)
+	case errors.Is(err, datastore.ErrVersionMismatch):
+		http.Error(rw, err.Error(), http.StatusPreconditionFailed)
+	case errors.Is(err, datastore.ErrTypeMismatch):
+		http.Error(rw, err.Error(), http.StatusConflict)
+	case errors.Is(err, datastore.ErrClosed):
+		http.Error(rw, "database is closed", http.StatusServiceUnavailable)
+	default:
+		logger.Error("Database request failed", "err", err)
+		http.Error(rw, "internal error", http.StatusInternalServerError)
+	}
+}
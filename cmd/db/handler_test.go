@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/roman-mazur/architecture-practice-4-template/datastore"
+)
+
+func TestHandler(t *testing.T) {
+	db, err := datastore.NewDb(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := db.PutInt64("counter", 1); err != nil {
+		t.Fatal(err)
+	}
+	handler := newHandler(db, slog.Default())
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return rw
+	}
+
+	if rw := do("GET", "/db/team", ""); rw.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for missing key, got %d", rw.Code)
+	}
+	if rw := do("POST", "/db/team", `{"value": "2024-05-01"}`); rw.Code != http.StatusNoContent {
+		t.Errorf("Expected 204 for put, got %d: %s", rw.Code, rw.Body)
+	}
+	rw := do("GET", "/db/team", "")
+	if rw.Code != http.StatusOK {
+		t.Fatalf("Expected 200 for existing key, got %d", rw.Code)
+	}
+	var record Record
+	if err := json.NewDecoder(rw.Body).Decode(&record); err != nil {
+		t.Fatal(err)
+	}
+	if record != (Record{Key: "team", Value: "2024-05-01"}) {
+		t.Errorf("Bad record: %+v", record)
+	}
+
+	for _, body := range []string{"", "not json", `{"other": "x"}`, `{"value": 1}`} {
+		if rw := do("POST", "/db/team", body); rw.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for body %q, got %d", body, rw.Code)
+		}
+	}
+	if rw := do("GET", "/db/counter", ""); rw.Code != http.StatusConflict {
+		t.Errorf("Expected 409 for non-string value, got %d", rw.Code)
+	}
+	if rw := do("PUT", "/db/team", `{"value": "x"}`); rw.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405 for PUT, got %d", rw.Code)
+	}
+
+	batch := `[{"key": "a", "value": "1"}, {"key": "b", "value": "2"}, {"key": "a", "delete": true}]`
+	if rw := do("POST", "/db", batch); rw.Code != http.StatusNoContent {
+		t.Errorf("Expected 204 for batch, got %d: %s", rw.Code, rw.Body)
+	}
+	if rw := do("GET", "/db/a", ""); rw.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for key deleted by batch, got %d", rw.Code)
+	}
+	if value, err := db.Get("b"); err != nil || value != "2" {
+		t.Errorf("Bad value written by batch: %s, %v", value, err)
+	}
+	for _, body := range []string{`{}`, `[{"value": "1"}]`, `[{"key": "a"}]`, `[{"key": "a", "value": "1", "delete": true}]`} {
+		if rw := do("POST", "/db", body); rw.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for batch %s, got %d", body, rw.Code)
+		}
+	}
+
+	rw = do("GET", "/backup", "")
+	restored := filepath.Join(t.TempDir(), "restored")
+	if err := datastore.Restore(rw.Body, restored); err != nil {
+		t.Fatalf("Cannot restore backup: %s", err)
+	}
+	if copied, err := datastore.NewDb(restored); err != nil {
+		t.Error(err)
+	} else {
+		if value, err := copied.Get("b"); err != nil || value != "2" {
+			t.Errorf("Bad value in backup: %s, %v", value, err)
+		}
+		copied.Close()
+	}
+
+	if rw := do("DELETE", "/db/team", ""); rw.Code != http.StatusNoContent {
+		t.Errorf("Expected 204 for delete, got %d", rw.Code)
+	}
+	if rw := do("GET", "/db/team", ""); rw.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for deleted key, got %d", rw.Code)
+	}
+}
+
+func TestHandler_Conditional(t *testing.T) {
+	db, err := datastore.NewDb(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	handler := newHandler(db, slog.Default())
+
+	do := func(method, path, ifMatch, body string) *httptest.ResponseRecorder {
+		rw := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if ifMatch != "" {
+			req.Header.Set("if-match", ifMatch)
+		}
+		handler.ServeHTTP(rw, req)
+		return rw
+	}
+
+	if rw := do("POST", "/db/key", `"0"`, `{"value": "v1"}`); rw.Code != http.StatusNoContent {
+		t.Fatalf("Expected 204 creating key, got %d: %s", rw.Code, rw.Body)
+	}
+	rw := do("GET", "/db/key", "", "")
+	if etag := rw.Header().Get("etag"); etag != `"1"` {
+		t.Errorf("Expected ETag \"1\", got %s", etag)
+	}
+	if rw := do("POST", "/db/key", `"0"`, `{"value": "v2"}`); rw.Code != http.StatusPreconditionFailed {
+		t.Errorf("Expected 412 for stale version, got %d", rw.Code)
+	}
+	if rw := do("POST", "/db/key", `"1"`, `{"value": "v2"}`); rw.Code != http.StatusNoContent {
+		t.Errorf("Expected 204 for matching version, got %d: %s", rw.Code, rw.Body)
+	}
+	if rw := do("POST", "/db/key", `bad`, `{"value": "v3"}`); rw.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for bad If-Match, got %d", rw.Code)
+	}
+	if rw := do("DELETE", "/db/key", `"1"`, ""); rw.Code != http.StatusPreconditionFailed {
+		t.Errorf("Expected 412 deleting stale version, got %d", rw.Code)
+	}
+	if rw := do("DELETE", "/db/key", `"2"`, ""); rw.Code != http.StatusNoContent {
+		t.Errorf("Expected 204 deleting matching version, got %d", rw.Code)
+	}
+	if rw := do("DELETE", "/db/key", `"0"`, ""); rw.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 deleting missing key, got %d", rw.Code)
+	}
+}
+
+func TestHandler_BackupFailure(t *testing.T) {
+	db, err := datastore.NewDb(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(newHandler(db, slog.Default()))
+	defer server.Close()
+	db.Close()
+
+	resp, err := http.Get(server.URL + "/backup")
+	if err == nil {
+		_, err = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+	if err == nil {
+		t.Error("Expected failed backup to break the transfer")
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/roman-mazur/architecture-practice-4-template/datastore"
+	"github.com/roman-mazur/architecture-practice-4-template/httptools"
+	"github.com/roman-mazur/architecture-practice-4-template/logging"
+	"github.com/roman-mazur/architecture-practice-4-template/signal"
+)
+
+var (
+	port      = flag.Int("port", 8091, "server port")
+	dir       = flag.String("dir", "db-data", "database directory")
+	compress  = flag.Int("compress", 0, "compress values of at least this many bytes, 0 to disable")
+	logConfig = logging.BindFlags(flag.CommandLine)
+)
+
+const shutdownTimeout = 5 * time.Second
+
+func main() {
+	flag.Parse()
+	logger := logging.New(*logConfig)
+	slog.SetDefault(logger)
+
+	if err := os.MkdirAll(*dir, 0o755); err != nil {
+		logger.Error("Cannot create database directory", "dir", *dir, "err", err)
+		os.Exit(1)
+	}
+	db, err := datastore.NewDb(*dir,
+		datastore.WithCompression(*compress),
+		datastore.WithLogger(logging.Component(logger, "datastore")),
+	)
+	if err != nil {
+		logger.Error("Cannot open database", "dir", *dir, "err", err)
+		os.Exit(1)
+	}
+
+	serverLogger := logging.Component(logger, "db")
+	server := httptools.CreateServer(*port, newHandler(db, serverLogger), httptools.WithLogger(serverLogger))
+	server.Start()
+
+	signal.WaitForTerminationSignal()
+
+	// Finish active requests before closing the database
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		serverLogger.Error("Cannot stop the HTTP server", "err", err)
+	}
+	if err := db.Close(); err != nil {
+		logger.Error("Cannot close database", "err", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/roman-mazur/architecture-practice-4-template/datastore"
+	"github.com/roman-mazur/architecture-practice-4-template/logging"
+)
+
+const usage = `Usage:
+  dbctl backup -url <db service> [-out <file>]   write a backup of a running database ("-" for stdout)
+  dbctl backup -url <db service> -to <dir>       copy a running database into a new directory
+  dbctl backup -dir <db dir> [-out <file> | -to <dir>]
+                                                 the same for a stopped database
+  dbctl restore -dir <db dir> [-in <file>]       create a database from a backup ("-" for stdin)
+`
+
+func main() {
+	if err := run(os.Args[1:], os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string, stdin io.Reader, stdout io.Writer) error {
+	if len(args) == 0 {
+		return fmt.Errorf("command is not defined\n%s", usage)
+	}
+	switch args[0] {
+	case "backup":
+		return backup(args[1:], stdout)
+	case "restore":
+		return restore(args[1:], stdin)
+	}
+	return fmt.Errorf("unknown command %s\n%s", args[0], usage)
+}
+
+func backup(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("backup", flag.ContinueOnError)
+	dir := fs.String("dir", "", "directory of a stopped database")
+	serviceURL := fs.String("url", "", "address of a running cmd/db service, e.g. http://localhost:8091")
+	out := fs.String("out", "-", "backup file")
+	to := fs.String("to", "", "directory for a copy of the database")
+	logConfig := logging.BindFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if (*dir == "") == (*serviceURL == "") {
+		return fmt.Errorf("either database directory or service URL must be defined")
+	}
+
+	var write func(w io.Writer) error
+	if *serviceURL != "" {
+		// The service writes the backup while serving requests
+		write = func(w io.Writer) error {
+			return download(*serviceURL, w)
+		}
+		if *to != "" {
+			pr, pw := io.Pipe()
+			go func() {
+				pw.CloseWithError(write(pw))
+			}()
+			err := datastore.Restore(pr, *to)
+			pr.CloseWithError(err)
+			return err
+		}
+	} else {
+		// Files of a running database change under another process, so it must
+		// be stopped. The directory is only read in case it is not.
+		logger := logging.Component(logging.New(*logConfig), "datastore")
+		db, err := datastore.NewDb(*dir, datastore.ReadOnly(), datastore.WithLogger(logger))
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		if *to != "" {
+			return db.BackupToDir(*to)
+		}
+		write = db.Backup
+	}
+
+	if *out == "-" {
+		return write(stdout)
+	}
+	f, err := os.OpenFile(*out, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		os.Remove(*out)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// download copies the backup streamed by the service to w
+// and checks that it is complete.
+func download(serviceURL string, w io.Writer) error {
+	resp, err := http.Get(strings.TrimSuffix(serviceURL, "/") + "/backup")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("backup failed with status %d: %s", resp.StatusCode, strings.TrimSpace(string(message)))
+	}
+	if err := datastore.VerifyBackup(io.TeeReader(resp.Body, w)); err != nil {
+		return fmt.Errorf("bad backup from %s: %w", serviceURL, err)
+	}
+	return nil
+}
+
+func restore(args []string, stdin io.Reader) error {
+	fs := flag.NewFlagSet("restore", flag.ContinueOnError)
+	dir := fs.String("dir", "", "database directory to create")
+	in := fs.String("in", "-", "backup file")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *dir == "" {
+		return fmt.Errorf("database directory is not defined")
+	}
+
+	if *in == "-" {
+		return datastore.Restore(stdin, *dir)
+	}
+	f, err := os.Open(*in)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return datastore.Restore(f, *dir)
+}
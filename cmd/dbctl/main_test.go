@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/roman-mazur/architecture-practice-4-template/datastore"
+)
+
+func TestBackupRestore(t *testing.T) {
+	dir := t.TempDir()
+	db, err := datastore.NewDb(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put("key", "value"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	backupFile := filepath.Join(t.TempDir(), "backup")
+	if err := run([]string{"backup", "-dir", dir, "-out", backupFile}, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	restored := filepath.Join(t.TempDir(), "restored")
+	if err := run([]string{"restore", "-dir", restored, "-in", backupFile}, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout bytes.Buffer
+	if err := run([]string{"backup", "-dir", restored}, nil, &stdout); err != nil {
+		t.Fatal(err)
+	}
+	piped := filepath.Join(t.TempDir(), "piped")
+	if err := run([]string{"restore", "-dir", piped}, &stdout, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = datastore.NewDb(piped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if value, err := db.Get("key"); err != nil || value != "value" {
+		t.Errorf("Bad value for key: %s, %v", value, err)
+	}
+}
+
+func TestBackup_Online(t *testing.T) {
+	db, err := datastore.NewDb(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := db.Put("key", "value"); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/backup" {
+			http.NotFound(rw, r)
+			return
+		}
+		db.Backup(rw)
+	}))
+	defer server.Close()
+
+	var stdout bytes.Buffer
+	if err := run([]string{"backup", "-url", server.URL}, nil, &stdout); err != nil {
+		t.Fatal(err)
+	}
+	restored := filepath.Join(t.TempDir(), "restored")
+	if err := run([]string{"restore", "-dir", restored}, &stdout, nil); err != nil {
+		t.Fatal(err)
+	}
+	copied := filepath.Join(t.TempDir(), "copied")
+	if err := run([]string{"backup", "-url", server.URL + "/", "-to", copied}, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, dir := range []string{restored, copied} {
+		backup, err := datastore.NewDb(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if value, err := backup.Get("key"); err != nil || value != "value" {
+			t.Errorf("Bad value for key in %s: %s, %v", dir, value, err)
+		}
+		backup.Close()
+	}
+
+	if err := run([]string{"backup", "-url", server.URL + "/missing"}, nil, &stdout); err == nil {
+		t.Error("Expected error for bad service URL")
+	}
+	if err := run([]string{"backup", "-url", server.URL, "-dir", restored}, nil, &stdout); err == nil {
+		t.Error("Expected error with both directory and service URL")
+	}
+}
+
+func TestBackup_Truncated(t *testing.T) {
+	db, err := datastore.NewDb(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := db.Put("key", "value"); err != nil {
+		t.Fatal(err)
+	}
+	var full bytes.Buffer
+	if err := db.Backup(&full); err != nil {
+		t.Fatal(err)
+	}
+	// The response ends normally, but the backup stream is cut short
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Write(full.Bytes()[:full.Len()-12])
+	}))
+	defer server.Close()
+
+	backupFile := filepath.Join(t.TempDir(), "backup")
+	if err := run([]string{"backup", "-url", server.URL, "-out", backupFile}, nil, nil); err == nil {
+		t.Error("Expected error for truncated backup")
+	}
+	if _, err := os.Stat(backupFile); !os.IsNotExist(err) {
+		t.Errorf("Expected truncated backup file to be removed, got %v", err)
+	}
+}
+
+func TestUnknownCommand(t *testing.T) {
+	if err := run([]string{"drop"}, nil, nil); err == nil {
+		t.Error("Expected error for unknown command")
+	}
+	if err := run(nil, nil, nil); err == nil {
+		t.Error("Expected error without command")
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+)
+
+// drainCheckInterval is how often draining backends are checked for requests in progress.
+var drainCheckInterval = 100 * time.Millisecond
+
+// backendStatus is the JSON representation of a backend in the admin API.
+type backendStatus struct {
+	Addr         string  `json:"addr"`
+	Weight       int     `json:"weight"`
+	Healthy      bool    `json:"healthy"`
+	Mode         string  `json:"mode"`
+	Source       string  `json:"source"`
+	Active       int     `json:"active"`
+	Requests     int     `json:"requests"`
+	Errors       int     `json:"errors"`
+	AvgLatencyMs float64 `json:"avgLatencyMs"`
+	MaxLatencyMs float64 `json:"maxLatencyMs"`
+}
+
+// newAdminHandler creates the admin API of the balancer:
+// GET /backends lists the pool with the health and request stats of every backend,
+// POST /backends adds a backend or updates its weight, DELETE /backends/{addr}
+// removes it and POST /backends/{addr}/{drain,disable,enable} changes its mode.
+// Backends added, updated or removed here are not changed by discovery afterwards.
+func newAdminHandler(hc *healthChecker, stats *poolStats, logger *slog.Logger) http.Handler {
+	h := http.NewServeMux()
+
+	status := func(server string) (backendStatus, bool) {
+		res, exists := hc.status(server)
+		if !exists {
+			return res, false
+		}
+		s := stats.get(server)
+		res.Active, res.Requests, res.Errors = s.active, s.requests, s.errors
+		if s.requests > 0 {
+			res.AvgLatencyMs = milliseconds(s.latency / time.Duration(s.requests))
+		}
+		res.MaxLatencyMs = milliseconds(s.maxLatency)
+		return res, true
+	}
+
+	h.HandleFunc("GET /backends", func(rw http.ResponseWriter, r *http.Request) {
+		res := make([]backendStatus, 0)
+		for _, server := range hc.all() {
+			if s, exists := status(server); exists {
+				res = append(res, s)
+			}
+		}
+		writeJSON(rw, http.StatusOK, res)
+	})
+
+	h.HandleFunc("GET /backends/{addr}", func(rw http.ResponseWriter, r *http.Request) {
+		s, exists := status(r.PathValue("addr"))
+		if !exists {
+			http.Error(rw, "unknown backend", http.StatusNotFound)
+			return
+		}
+		writeJSON(rw, http.StatusOK, s)
+	})
+
+	h.HandleFunc("POST /backends", func(rw http.ResponseWriter, r *http.Request) {
+		var b backend
+		if err := json.NewDecoder(r.Body).Decode(&b); err != nil || b.Addr == "" || b.Weight < 0 || b.Weight > maxWeight {
+			http.Error(rw, fmt.Sprintf("request body must be a JSON object with addr and optional weight up to %d", maxWeight), http.StatusBadRequest)
+			return
+		}
+		code := http.StatusOK
+		if _, exists := hc.status(b.Addr); !exists {
+			code = http.StatusCreated
+		}
+		hc.addManaged(b.Addr, b.Weight)
+		s, _ := status(b.Addr)
+		writeJSON(rw, code, s)
+	})
+
+	h.HandleFunc("DELETE /backends/{addr}", func(rw http.ResponseWriter, r *http.Request) {
+		if !hc.removeManaged(r.PathValue("addr")) {
+			http.Error(rw, "unknown backend", http.StatusNotFound)
+			return
+		}
+		rw.WriteHeader(http.StatusNoContent)
+	})
+
+	for action, mode := range map[string]string{"drain": modeDraining, "disable": modeDisabled, "enable": modeEnabled} {
+		h.HandleFunc("POST /backends/{addr}/"+action, func(rw http.ResponseWriter, r *http.Request) {
+			server := r.PathValue("addr")
+			if !hc.setMode(server, mode) {
+				http.Error(rw, "unknown backend", http.StatusNotFound)
+				return
+			}
+			if mode == modeDraining {
+				go drain(hc, stats, server, logger)
+			}
+			s, _ := status(server)
+			writeJSON(rw, http.StatusOK, s)
+		})
+	}
+
+	return h
+}
+
+// drain removes the server from the pool once it has no requests in progress,
+// unless it is enabled or removed meanwhile.
+func drain(hc *healthChecker, stats *poolStats, server string, logger *slog.Logger) {
+	ticker := time.NewTicker(drainCheckInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if s, exists := hc.status(server); !exists || s.Mode != modeDraining {
+			return
+		}
+		if stats.get(server).active == 0 {
+			if hc.removeDrained(server) {
+				logger.Info("Backend drained", "server", server)
+			}
+			return
+		}
+	}
+}
+
+// requireToken lets through only requests with the bearer token, an empty token disables the check.
+func requireToken(token string, h http.Handler) http.Handler {
+	if token == "" {
+		return h
+	}
+	expected := []byte("Bearer " + token)
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			rw.Header().Set("www-authenticate", "Bearer")
+			http.Error(rw, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(rw, r)
+	})
+}
+
+// isLoopback tells whether the host is reachable from the same machine only.
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+func writeJSON(rw http.ResponseWriter, status int, v any) {
+	rw.Header().Set("content-type", "application/json")
+	rw.WriteHeader(status)
+	_ = json.NewEncoder(rw).Encode(v)
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func adminRequest(t *testing.T, h http.Handler, method, target, body string, out any) int {
+	t.Helper()
+	rw := httptest.NewRecorder()
+	h.ServeHTTP(rw, httptest.NewRequest(method, target, strings.NewReader(body)))
+	if out != nil && rw.Code < 300 {
+		if err := json.NewDecoder(rw.Body).Decode(out); err != nil {
+			t.Fatalf("Bad response to %s %s: %s", method, target, err)
+		}
+	}
+	return rw.Code
+}
+
+func TestAdmin_Stats(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fail" {
+			rw.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+	addr := server.Listener.Addr().String()
+
+	hc := newHealthChecker([]string{addr}, nil, time.Second, 1, 1, slog.Default())
+	strategy := new(roundRobin)
+	attach(hc, strategy)
+	stats := newPoolStats()
+	lb := balancer(strategy, stats)
+	for _, path := range []string{"/ok", "/ok", "/fail"} {
+		lb.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	var backends []backendStatus
+	if code := adminRequest(t, newAdminHandler(hc, stats, slog.Default()), "GET", "/backends", "", &backends); code != http.StatusOK {
+		t.Fatalf("Unexpected status %d", code)
+	}
+	if len(backends) != 1 {
+		t.Fatalf("Expected one backend, got %v", backends)
+	}
+	b := backends[0]
+	if b.Addr != addr || !b.Healthy || b.Mode != modeEnabled || b.Weight != 1 {
+		t.Errorf("Unexpected backend state %+v", b)
+	}
+	if b.Requests != 3 || b.Errors != 1 || b.Active != 0 || b.MaxLatencyMs <= 0 || b.AvgLatencyMs > b.MaxLatencyMs {
+		t.Errorf("Unexpected backend stats %+v", b)
+	}
+}
+
+func TestAdmin_Control(t *testing.T) {
+	hc := newHealthChecker([]string{"a"}, nil, time.Second, 1, 1, slog.Default())
+	strategy := new(weightedRandom)
+	attach(hc, strategy)
+	stats := newPoolStats()
+	admin := newAdminHandler(hc, stats, slog.Default())
+
+	var b backendStatus
+	if code := adminRequest(t, admin, "POST", "/backends", `{"addr": "b", "weight": 2}`, &b); code != http.StatusCreated || b.Weight != 2 {
+		t.Errorf("Expected b to be added, got %d %+v", code, b)
+	}
+	if code := adminRequest(t, admin, "POST", "/backends", `{"addr": "b", "weight": 3}`, &b); code != http.StatusOK || b.Weight != 3 {
+		t.Errorf("Expected b weight to be updated, got %d %+v", code, b)
+	}
+	if code := adminRequest(t, admin, "POST", "/backends", `{"weight": 3}`, nil); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 without addr, got %d", code)
+	}
+
+	if code := adminRequest(t, admin, "POST", "/backends/a/disable", "", &b); code != http.StatusOK || b.Mode != modeDisabled {
+		t.Errorf("Expected a to be disabled, got %d %+v", code, b)
+	}
+	if counts := selectN(strategy, 10); counts["b"] != 10 {
+		t.Errorf("Expected disabled a to get no requests, got %v", counts)
+	}
+	hc.report("a", true)
+	if counts := selectN(strategy, 10); counts["b"] != 10 {
+		t.Errorf("Expected health checks not to enable a, got %v", counts)
+	}
+	if code := adminRequest(t, admin, "POST", "/backends/a/enable", "", &b); code != http.StatusOK || b.Mode != modeEnabled {
+		t.Errorf("Expected a to be enabled, got %d %+v", code, b)
+	}
+	if counts := selectN(strategy, 100); counts["a"] == 0 {
+		t.Errorf("Expected enabled a to get requests, got %v", counts)
+	}
+
+	if code := adminRequest(t, admin, "DELETE", "/backends/b", "", nil); code != http.StatusNoContent {
+		t.Errorf("Expected b to be removed, got %d", code)
+	}
+	for _, method := range []string{"GET", "DELETE"} {
+		if code := adminRequest(t, admin, method, "/backends/b", "", nil); code != http.StatusNotFound {
+			t.Errorf("Expected 404 for %s of removed b, got %d", method, code)
+		}
+	}
+	if code := adminRequest(t, admin, "POST", "/backends/b/drain", "", nil); code != http.StatusNotFound {
+		t.Errorf("Expected 404 for drain of removed b, got %d", code)
+	}
+}
+
+func TestAdmin_Discovery(t *testing.T) {
+	hc := newHealthChecker(nil, nil, time.Second, 1, 1, slog.Default())
+	hc.setBackends([]backend{{Addr: "a"}, {Addr: "b"}})
+	admin := newAdminHandler(hc, newPoolStats(), slog.Default())
+
+	adminRequest(t, admin, "POST", "/backends", `{"addr": "c"}`, nil)
+	adminRequest(t, admin, "DELETE", "/backends/b", "", nil)
+	adminRequest(t, admin, "POST", "/backends", `{"addr": "a", "weight": 5}`, nil)
+	hc.setBackends([]backend{{Addr: "a", Weight: 2}, {Addr: "b"}, {Addr: "d"}})
+	if servers := hc.all(); !reflect.DeepEqual(servers, []string{"a", "c", "d"}) {
+		t.Errorf("Expected discovery to keep admin changes, got %v", servers)
+	}
+	if s, _ := hc.status("a"); s.Weight != 5 || s.Source != sourceAdmin {
+		t.Errorf("Expected a to keep the weight set through the admin API, got %+v", s)
+	}
+	if s, _ := hc.status("d"); s.Source != sourceDiscovery {
+		t.Errorf("Expected d to be discovered, got %+v", s)
+	}
+
+	hc.setBackends(nil)
+	if servers := hc.all(); !reflect.DeepEqual(servers, []string{"a", "c"}) {
+		t.Errorf("Expected only discovered backends to be removed, got %v", servers)
+	}
+
+	// Adding through the admin API cancels the removal
+	adminRequest(t, admin, "POST", "/backends", `{"addr": "b"}`, nil)
+	adminRequest(t, admin, "DELETE", "/backends/b", "", nil)
+	hc.setBackends([]backend{{Addr: "b"}})
+	if _, exists := hc.status("b"); exists {
+		t.Error("Expected b to stay removed")
+	}
+	adminRequest(t, admin, "POST", "/backends", `{"addr": "b"}`, nil)
+	hc.setBackends(nil)
+	if _, exists := hc.status("b"); !exists {
+		t.Error("Expected b added through the admin API to stay")
+	}
+}
+
+func TestAdmin_Token(t *testing.T) {
+	hc := newHealthChecker([]string{"a"}, nil, time.Second, 1, 1, slog.Default())
+	admin := requireToken("secret", newAdminHandler(hc, newPoolStats(), slog.Default()))
+	for header, expected := range map[string]int{
+		"":              http.StatusUnauthorized,
+		"Bearer wrong":  http.StatusUnauthorized,
+		"secret":        http.StatusUnauthorized,
+		"Bearer secret": http.StatusOK,
+	} {
+		rw := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/backends", nil)
+		if header != "" {
+			r.Header.Set("Authorization", header)
+		}
+		admin.ServeHTTP(rw, r)
+		if rw.Code != expected {
+			t.Errorf("Expected %d for %q, got %d", expected, header, rw.Code)
+		}
+	}
+
+	for host, expected := range map[string]bool{"127.0.0.1": true, "::1": true, "localhost": true, "": false, "0.0.0.0": false, "10.0.0.5": false} {
+		if isLoopback(host) != expected {
+			t.Errorf("Expected isLoopback(%q) to be %t", host, expected)
+		}
+	}
+}
+
+func TestAdmin_Drain(t *testing.T) {
+	drainCheckInterval = time.Millisecond
+	defer func() { drainCheckInterval = 100 * time.Millisecond }()
+
+	hc := newHealthChecker([]string{"a", "b"}, nil, time.Second, 1, 1, slog.Default())
+	strategy := new(roundRobin)
+	attach(hc, strategy)
+	stats := newPoolStats()
+	admin := newAdminHandler(hc, stats, slog.Default())
+
+	finish := stats.start("a")
+	var b backendStatus
+	if code := adminRequest(t, admin, "POST", "/backends/a/drain", "", &b); code != http.StatusOK || b.Mode != modeDraining || b.Active != 1 {
+		t.Fatalf("Expected a to be draining, got %d %+v", code, b)
+	}
+	if counts := selectN(strategy, 10); counts["b"] != 10 {
+		t.Errorf("Expected draining a to get no requests, got %v", counts)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if _, exists := hc.status("a"); !exists {
+		t.Fatal("Expected a to stay in the pool with a request in progress")
+	}
+
+	finish(false)
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		if _, exists := hc.status("a"); !exists {
+			hc.setBackends([]backend{{Addr: "a"}, {Addr: "b"}})
+			if _, exists := hc.status("a"); exists {
+				t.Error("Expected drained a not to be restored by discovery")
+			}
+			return
+		}
+	}
+	t.Error("Expected drained a to be removed")
+}
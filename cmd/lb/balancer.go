@@ -4,25 +4,41 @@ import (
 	"context"
 	"flag"
 	"fmt"
-	"hash/fnv"
 	"io"
-	"log"
+	"log/slog"
+	"net"
 	"net/http"
-	"sync"
+	"os"
 	"time"
 
 	"github.com/roman-mazur/architecture-practice-4-template/httptools"
+	"github.com/roman-mazur/architecture-practice-4-template/logging"
 	"github.com/roman-mazur/architecture-practice-4-template/signal"
 )
 
 var (
-	port         = flag.Int("port", 8090, "load balancer port")
-	timeoutSec   = flag.Int("timeout-sec", 3, "request timeout time in seconds")
-	https        = flag.Bool("https", false, "whether backends support HTTPs")
-	traceEnabled = flag.Bool("trace", false, "whether to include tracing information into responses")
-	timeout      = time.Duration(*timeoutSec) * time.Second
-	serversPool  = []string{"server1:8080", "server2:8080", "server3:8080"}
-	mu           sync.Mutex
+	port           = flag.Int("port", 8090, "load balancer port")
+	adminPort      = flag.Int("admin-port", 8095, "admin API port, 0 disables the API")
+	adminHost      = flag.String("admin-host", "127.0.0.1", "address the admin API listens on, other than loopback requires a token")
+	adminToken     = flag.String("admin-token", os.Getenv("LB_ADMIN_TOKEN"), "bearer token required by the admin API, defaults to $LB_ADMIN_TOKEN")
+	timeoutSec     = flag.Int("timeout-sec", 3, "request timeout time in seconds")
+	https          = flag.Bool("https", false, "whether backends support HTTPs")
+	traceEnabled   = flag.Bool("trace", false, "whether to include tracing information into responses")
+	healthInterval = flag.Duration("health-interval", 10*time.Second, "interval between backend health checks")
+	healthRise     = flag.Int("health-rise", 2, "successful checks to re-admit an unhealthy backend")
+	healthFall     = flag.Int("health-fall", 3, "failed checks to mark a backend unhealthy")
+	strategyName   = flag.String("strategy", strategyHash, "balancing strategy: round-robin, least-conn, weighted-random, p2c or hash")
+	hashKey        = flag.String("hash-key", "path", "request key of the hash strategy: path, ip, header:<name> or cookie:<name>")
+	hashReplicas   = flag.Int("hash-replicas", 100, "virtual nodes of a backend on the hash ring")
+	weightsFlag    = flag.String("weights", "", "backend weights, e.g. server1:8080=2,server2:8080=1")
+	configFile     = flag.String("config", "", "JSON file with the backends, reloaded when it changes")
+	dnsName        = flag.String("dns", "", "DNS name resolving to the backends, SRV if it starts with an underscore")
+	dnsPort        = flag.Int("dns-port", 8080, "backend port for the A/AAAA records of the DNS name")
+	discoveryEvery = flag.Duration("discovery-interval", 10*time.Second, "interval between backend discovery lookups")
+	logConfig      = logging.BindFlags(flag.CommandLine)
+	timeout        = 3 * time.Second // Set from timeoutSec once flags are parsed
+	serversPool    = []string{"server1:8080", "server2:8080", "server3:8080"}
+	logger         = slog.Default()
 )
 
 func scheme() string {
@@ -33,20 +49,25 @@ func scheme() string {
 }
 
 func health(dst string) bool {
-	ctx, _ := context.WithTimeout(context.Background(), timeout)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
 	req, _ := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s://%s/health", scheme(), dst), nil)
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
+		logger.Debug("Health check failed", "server", dst, "err", err)
 		return false
 	}
+	resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
+		logger.Debug("Health check failed", "server", dst, "status", resp.StatusCode)
 		return false
 	}
 	return true
 }
 
 func forward(dst string, rw http.ResponseWriter, r *http.Request) error {
-	ctx, _ := context.WithTimeout(r.Context(), timeout)
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
 	fwdRequest := r.Clone(ctx)
 	fwdRequest.RequestURI = ""
 	fwdRequest.URL.Host = dst
@@ -63,60 +84,95 @@ func forward(dst string, rw http.ResponseWriter, r *http.Request) error {
 		if *traceEnabled {
 			rw.Header().Set("lb-from", dst)
 		}
-		log.Println("fwd", resp.StatusCode, resp.Request.URL)
+		logger.Debug("fwd", "status", resp.StatusCode, "url", resp.Request.URL)
 		rw.WriteHeader(resp.StatusCode)
 		defer resp.Body.Close()
 		_, err := io.Copy(rw, resp.Body)
 		if err != nil {
-			log.Printf("Failed to write response: %s", err)
+			logger.Warn("Failed to write response", "err", err)
 		}
 		return nil
 	} else {
-		log.Printf("Failed to get response from %s: %s", dst, err)
+		logger.Warn("Failed to get response", "server", dst, "err", err)
 		rw.WriteHeader(http.StatusServiceUnavailable)
 		return err
 	}
 }
 
-func hash(s string) int {
-	h := fnv.New32a()
-	h.Write([]byte(s))
-	return int(h.Sum32())
+// balancer forwards requests to backends chosen by the strategy.
+func balancer(strategy Strategy, stats *poolStats) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		server, done := strategy.Select(r)
+		defer done()
+		if server == "" {
+			logger.Warn("No healthy backends", "url", r.URL)
+			http.Error(rw, "no healthy backends", http.StatusServiceUnavailable)
+			return
+		}
+		finish := stats.start(server)
+		sw := &statusWriter{ResponseWriter: rw, status: http.StatusOK}
+		err := forward(server, sw, r)
+		finish(err != nil || sw.status >= http.StatusInternalServerError)
+	})
 }
 
-func getServerByHash(urlPath string) string {
-	serverIndex := hash(urlPath) % len(serversPool)
-	return serversPool[serverIndex]
+// attach makes the strategy follow the pool and the health of its servers.
+func attach(hc *healthChecker, strategy Strategy) {
+	hc.watch(func(server string, weight int, available bool) {
+		if available {
+			strategy.Add(server, weight)
+		} else {
+			strategy.Remove(server)
+		}
+	})
 }
 
 func main() {
 	flag.Parse()
+	timeout = time.Duration(*timeoutSec) * time.Second
+	logger = logging.Component(logging.New(*logConfig), "balancer")
 
-	for _, server := range serversPool {
-		go func(server string) {
-			for range time.Tick(10 * time.Second) {
-				if !health(server) {
-					mu.Lock()
-					// Remove unhealthy server
-					for i, srv := range serversPool {
-						if srv == server {
-							serversPool = append(serversPool[:i], serversPool[i+1:]...)
-							break
-						}
-					}
-					mu.Unlock()
-				}
-			}
-		}(server)
+	weights, err := parseWeights(*weightsFlag)
+	if err != nil {
+		logger.Error("Invalid configuration", "err", err)
+		os.Exit(1)
+	}
+	strategy, err := newStrategy(*strategyName, *hashKey, *hashReplicas)
+	if err != nil {
+		logger.Error("Invalid configuration", "err", err)
+		os.Exit(1)
+	}
+	if *adminPort != 0 && *adminToken == "" && !isLoopback(*adminHost) {
+		logger.Error("Invalid configuration", "err", "admin API outside of loopback requires -admin-token")
+		os.Exit(1)
+	}
+	if *configFile != "" && *dnsName != "" {
+		logger.Error("Invalid configuration", "err", "only one of -config and -dns can be set")
+		os.Exit(1)
 	}
 
-	frontend := httptools.CreateServer(*port, http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
-		server := getServerByHash(r.URL.Path)
-		forward(server, rw, r)
-	}))
+	ctx := context.Background()
+	hc := newHealthChecker(nil, health, *healthInterval, *healthRise, *healthFall, logger)
+	attach(hc, strategy)
+	switch {
+	case *configFile != "":
+		go discover(ctx, hc, fileSource(*configFile), *discoveryEvery, logger)
+	case *dnsName != "":
+		go discover(ctx, hc, dnsSource(net.DefaultResolver, *dnsName, *dnsPort), *discoveryEvery, logger)
+	default:
+		hc.setBackends(staticBackends(serversPool, weights))
+	}
+	go hc.run(ctx)
+
+	stats := newPoolStats()
+	frontend := httptools.CreateServer(*port, balancer(strategy, stats), httptools.WithLogger(logger))
+	if *adminPort != 0 {
+		handler := requireToken(*adminToken, newAdminHandler(hc, stats, logger))
+		admin := httptools.CreateServer(*adminPort, handler, httptools.WithHost(*adminHost), httptools.WithLogger(logger))
+		admin.Start()
+	}
 
-	log.Println("Starting load balancer...")
-	log.Printf("Tracing support enabled: %t", *traceEnabled)
+	logger.Info("Starting load balancer", "port", *port, "admin", *adminPort, "trace", *traceEnabled, "strategy", *strategyName)
 	frontend.Start()
 	signal.WaitForTerminationSignal()
 }
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// backend is a server of the pool with its weight.
+type backend struct {
+	Addr   string `json:"addr"`
+	Weight int    `json:"weight,omitempty"`
+}
+
+// lookupFunc returns the current backends of a discovery source.
+type lookupFunc func(ctx context.Context) ([]backend, error)
+
+// staticBackends returns the servers with the configured weights.
+func staticBackends(servers []string, weights map[string]int) []backend {
+	res := make([]backend, len(servers))
+	for i, server := range servers {
+		res[i] = backend{Addr: server, Weight: weights[server]}
+	}
+	return res
+}
+
+// fileSource reads backends from a JSON file like
+// {"backends": [{"addr": "server1:8080", "weight": 2}]}.
+func fileSource(path string) lookupFunc {
+	return func(context.Context) ([]backend, error) {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		var config struct {
+			Backends []backend `json:"backends"`
+		}
+		if err := json.Unmarshal(data, &config); err != nil {
+			return nil, fmt.Errorf("bad config %s: %w", path, err)
+		}
+		for _, b := range config.Backends {
+			if b.Addr == "" || b.Weight < 0 || b.Weight > maxWeight {
+				return nil, fmt.Errorf("bad backend %+v in %s", b, path)
+			}
+		}
+		return config.Backends, nil
+	}
+}
+
+// dnsSource resolves backends from SRV records when the name starts
+// with an underscore, e.g. _http._tcp.servers, and from A/AAAA records
+// with the given port otherwise.
+func dnsSource(resolver *net.Resolver, name string, port int) lookupFunc {
+	return func(ctx context.Context) ([]backend, error) {
+		if strings.HasPrefix(name, "_") {
+			_, records, err := resolver.LookupSRV(ctx, "", "", name)
+			if err != nil {
+				return nil, err
+			}
+			res := make([]backend, len(records))
+			for i, r := range records {
+				addr := net.JoinHostPort(strings.TrimSuffix(r.Target, "."), strconv.Itoa(int(r.Port)))
+				res[i] = backend{Addr: addr, Weight: int(r.Weight)}
+			}
+			return normalizeWeights(res), nil
+		}
+
+		hosts, err := resolver.LookupHost(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		res := make([]backend, len(hosts))
+		for i, host := range hosts {
+			res[i] = backend{Addr: net.JoinHostPort(host, strconv.Itoa(port))}
+		}
+		return res, nil
+	}
+}
+
+// normalizeWeights scales the weights down to maxWeight keeping their ratios,
+// SRV weights go up to 65535.
+func normalizeWeights(backends []backend) []backend {
+	top := 0
+	for _, b := range backends {
+		top = max(top, b.Weight)
+	}
+	if top <= maxWeight {
+		return backends
+	}
+	for i := range backends {
+		backends[i].Weight = max((backends[i].Weight*maxWeight+top-1)/top, 1)
+	}
+	return backends
+}
+
+// discover updates the pool from the source every interval until the context
+// is done. The pool changes only when the source does, lookup failures keep
+// the current backends. Backends managed through the admin API are not touched.
+func discover(ctx context.Context, hc *healthChecker, lookup lookupFunc, interval time.Duration, logger *slog.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var last []backend
+	for {
+		backends, err := lookup(ctx)
+		if err != nil {
+			logger.Warn("Backend discovery failed", "err", err)
+		} else {
+			slices.SortFunc(backends, func(a, b backend) int {
+				return strings.Compare(a.Addr, b.Addr)
+			})
+			if !slices.Equal(backends, last) {
+				logger.Info("Backends discovered", "count", len(backends))
+				hc.setBackends(backends)
+				last = backends
+			}
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
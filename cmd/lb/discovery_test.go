@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestFileSource(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "backends.json")
+	lookup := fileSource(path)
+	if _, err := lookup(context.Background()); err == nil {
+		t.Error("Expected error for missing file")
+	}
+
+	os.WriteFile(path, []byte(`{"backends": [{"addr": "a:8080", "weight": 2}, {"addr": "b:8080"}]}`), 0o644)
+	backends, err := lookup(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []backend{{Addr: "a:8080", Weight: 2}, {Addr: "b:8080"}}
+	if !reflect.DeepEqual(backends, expected) {
+		t.Errorf("Expected %v, got %v", expected, backends)
+	}
+
+	for _, config := range []string{`{"backends": [`, `{"backends": [{"weight": 1}]}`, `{"backends": [{"addr": "a", "weight": -1}]}`, `{"backends": [{"addr": "a", "weight": 101}]}`} {
+		os.WriteFile(path, []byte(config), 0o644)
+		if _, err := lookup(context.Background()); err == nil {
+			t.Errorf("Expected error for %s", config)
+		}
+	}
+}
+
+func TestDnsSource(t *testing.T) {
+	backends, err := dnsSource(net.DefaultResolver, "localhost", 8080)(context.Background())
+	if err != nil {
+		t.Skipf("Cannot resolve localhost: %s", err)
+	}
+	for _, b := range backends {
+		if b.Addr != "127.0.0.1:8080" && b.Addr != "[::1]:8080" {
+			t.Errorf("Unexpected backend %v", b)
+		}
+	}
+}
+
+func TestNormalizeWeights(t *testing.T) {
+	backends := normalizeWeights([]backend{{Addr: "a", Weight: 65535}, {Addr: "b", Weight: 1000}, {Addr: "c"}})
+	expected := []backend{{Addr: "a", Weight: 100}, {Addr: "b", Weight: 2}, {Addr: "c", Weight: 1}}
+	if !reflect.DeepEqual(backends, expected) {
+		t.Errorf("Expected %v, got %v", expected, backends)
+	}
+	small := []backend{{Addr: "a", Weight: 3}, {Addr: "b", Weight: 1}}
+	if backends := normalizeWeights(slices.Clone(small)); !reflect.DeepEqual(backends, small) {
+		t.Errorf("Expected weights within the limit to be kept, got %v", backends)
+	}
+
+	hc := newHealthChecker(nil, nil, time.Second, 1, 1, slog.Default())
+	hc.add("a", 65535)
+	if s, _ := hc.status("a"); s.Weight != maxWeight {
+		t.Errorf("Expected weight to be limited to %d, got %d", maxWeight, s.Weight)
+	}
+}
+
+func TestHealthChecker_SetBackends(t *testing.T) {
+	hc := newHealthChecker([]string{"a", "b"}, nil, time.Second, 1, 1, slog.Default())
+	strategy := new(weightedRandom)
+	attach(hc, strategy)
+
+	hc.setBackends([]backend{{Addr: "b", Weight: 3}, {Addr: "c"}})
+	if servers := hc.all(); !reflect.DeepEqual(servers, []string{"b", "c"}) {
+		t.Errorf("Expected b and c in the pool, got %v", servers)
+	}
+	strategy.mu.RLock()
+	if !reflect.DeepEqual(strategy.weights, map[string]int{"b": 3, "c": 1}) {
+		t.Errorf("Strategy does not follow the pool: %v", strategy.weights)
+	}
+	strategy.mu.RUnlock()
+
+	// Removed servers do not come back with late check results
+	hc.report("a", true)
+	if hc.remove("a") || slices.Contains(hc.healthy(), "a") {
+		t.Error("Expected a to stay removed")
+	}
+}
+
+func TestDiscover(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "backends.json")
+	os.WriteFile(path, []byte(`{"backends": [{"addr": "a"}, {"addr": "b"}]}`), 0o644)
+
+	check := func(string) bool { return true }
+	hc := newHealthChecker(nil, check, 5*time.Millisecond, 1, 1, slog.Default())
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{}, 2)
+	go func() {
+		hc.run(ctx)
+		done <- struct{}{}
+	}()
+	go func() {
+		discover(ctx, hc, fileSource(path), 5*time.Millisecond, slog.Default())
+		done <- struct{}{}
+	}()
+	defer func() {
+		cancel()
+		<-done
+		<-done
+	}()
+
+	waitFor := func(expected []string) {
+		t.Helper()
+		for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+			if reflect.DeepEqual(hc.healthy(), expected) {
+				return
+			}
+		}
+		t.Fatalf("Expected %v, got %v", expected, hc.healthy())
+	}
+	waitFor([]string{"a", "b"})
+
+	// Broken config keeps the current pool
+	os.WriteFile(path, []byte(`{`), 0o644)
+	time.Sleep(20 * time.Millisecond)
+	waitFor([]string{"a", "b"})
+
+	os.WriteFile(path, []byte(`{"backends": [{"addr": "b"}, {"addr": "c"}]}`), 0o644)
+	waitFor([]string{"b", "c"})
+}
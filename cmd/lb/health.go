@@ -0,0 +1,319 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Administrative modes of a backend, only enabled ones receive requests.
+// Draining backends are removed from the pool once their requests finish.
+const (
+	modeEnabled  = "enabled"
+	modeDisabled = "disabled"
+	modeDraining = "draining"
+)
+
+// healthState tracks the health of a single backend.
+type healthState struct {
+	weight    int
+	healthy   bool
+	mode      string
+	source    string             // Who added the backend, sourceDiscovery or sourceAdmin
+	successes int                // Consecutive successful checks
+	failures  int                // Consecutive failed checks
+	stop      context.CancelFunc // Stops the checks, nil until they are started
+}
+
+// Sources of the backends in the pool.
+const (
+	sourceDiscovery = "discovery" // Static list, config file or DNS
+	sourceAdmin     = "admin"     // Added or updated through the admin API
+)
+
+func (s *healthState) available() bool {
+	return s.healthy && s.mode == modeEnabled
+}
+
+// healthChecker keeps the pool of backends and periodically checks them to
+// track the ones that can receive requests. A healthy backend becomes
+// unhealthy after fall failed checks in a row and is re-admitted after rise
+// successful ones. Backends can be added and removed at any time.
+type healthChecker struct {
+	check    func(server string) bool
+	interval time.Duration
+	rise     int
+	fall     int
+	logger   *slog.Logger
+
+	mu       sync.RWMutex
+	ctx      context.Context                                 // Set by run
+	wg       sync.WaitGroup                                  // Running checks
+	onChange func(server string, weight int, available bool) // Called under the lock, may be nil
+	servers  []string                                        // In the order they were added
+	states   map[string]*healthState
+	removed  map[string]bool // Removed through the admin API, not restored by discovery
+}
+
+// newHealthChecker creates a checker of the servers with weight 1.
+// New servers are considered healthy until they fail the checks.
+func newHealthChecker(servers []string, check func(server string) bool, interval time.Duration, rise, fall int, logger *slog.Logger) *healthChecker {
+	hc := &healthChecker{
+		check:    check,
+		interval: interval,
+		rise:     max(rise, 1),
+		fall:     max(fall, 1),
+		logger:   logger,
+		removed:  make(map[string]bool),
+		states:   make(map[string]*healthState, len(servers)),
+	}
+	for _, server := range servers {
+		hc.add(server, 1)
+	}
+	return hc
+}
+
+// run checks the servers until the context is done.
+func (hc *healthChecker) run(ctx context.Context) {
+	hc.mu.Lock()
+	hc.ctx = ctx
+	for _, server := range hc.servers {
+		hc.startLocked(server)
+	}
+	hc.mu.Unlock()
+
+	<-ctx.Done()
+	hc.wg.Wait()
+}
+
+func (hc *healthChecker) startLocked(server string) {
+	ctx, stop := context.WithCancel(hc.ctx)
+	hc.states[server].stop = stop
+	hc.wg.Add(1)
+	go func() {
+		defer hc.wg.Done()
+		ticker := time.NewTicker(hc.interval)
+		defer ticker.Stop()
+		for {
+			ok := hc.check(server)
+			if ctx.Err() != nil {
+				return // Removed meanwhile
+			}
+			hc.report(server, ok)
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// add puts the server into the pool or updates its weight.
+// The weight is limited to 1..maxWeight.
+func (hc *healthChecker) add(server string, weight int) {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	hc.addLocked(server, weight, sourceDiscovery)
+}
+
+// addManaged adds the server through the admin API. Discovery does not
+// change or remove it afterwards and an earlier removal is cancelled.
+func (hc *healthChecker) addManaged(server string, weight int) {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	delete(hc.removed, server)
+	hc.addLocked(server, weight, sourceAdmin)
+}
+
+func (hc *healthChecker) addLocked(server string, weight int, source string) {
+	weight = min(max(weight, 1), maxWeight)
+	if state, exists := hc.states[server]; exists {
+		if source == sourceAdmin {
+			state.source = sourceAdmin
+		}
+		if state.weight != weight {
+			state.weight = weight
+			if state.available() {
+				hc.changed(server, state)
+			}
+		}
+		return
+	}
+	state := &healthState{weight: weight, healthy: true, mode: modeEnabled, source: source}
+	hc.servers = append(hc.servers, server)
+	hc.states[server] = state
+	hc.logger.Info("Backend added", "server", server, "weight", weight, "source", source)
+	hc.changed(server, state)
+	if hc.ctx != nil && hc.ctx.Err() == nil {
+		hc.startLocked(server)
+	}
+}
+
+// remove takes the server out of the pool.
+func (hc *healthChecker) remove(server string) bool {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	return hc.removeLocked(server)
+}
+
+// removeManaged removes the server through the admin API,
+// discovery does not bring it back until it is added again.
+func (hc *healthChecker) removeManaged(server string) bool {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	if !hc.removeLocked(server) {
+		return false
+	}
+	hc.removed[server] = true
+	return true
+}
+
+// removeDrained removes the server like removeManaged if it is still draining.
+func (hc *healthChecker) removeDrained(server string) bool {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	if state, exists := hc.states[server]; !exists || state.mode != modeDraining {
+		return false
+	}
+	hc.removeLocked(server)
+	hc.removed[server] = true
+	return true
+}
+
+func (hc *healthChecker) removeLocked(server string) bool {
+	state, exists := hc.states[server]
+	if !exists {
+		return false
+	}
+	if state.stop != nil {
+		state.stop()
+	}
+	hc.servers = slices.DeleteFunc(hc.servers, func(s string) bool { return s == server })
+	delete(hc.states, server)
+	hc.logger.Info("Backend removed", "server", server)
+	if state.available() {
+		state.healthy = false
+		hc.changed(server, state)
+	}
+	return true
+}
+
+// setMode changes the administrative mode of the server.
+func (hc *healthChecker) setMode(server, mode string) bool {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	state, exists := hc.states[server]
+	if !exists {
+		return false
+	}
+	if state.mode != mode {
+		available := state.available()
+		state.mode = mode
+		hc.logger.Info("Backend mode changed", "server", server, "mode", mode)
+		if state.available() != available {
+			hc.changed(server, state)
+		}
+	}
+	return true
+}
+
+// setBackends replaces the discovered backends of the pool with the given ones.
+// Backends added or removed through the admin API are left as they are.
+func (hc *healthChecker) setBackends(backends []backend) {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	keep := make(map[string]bool, len(backends))
+	for _, b := range backends {
+		keep[b.Addr] = true
+		if state, exists := hc.states[b.Addr]; hc.removed[b.Addr] || exists && state.source == sourceAdmin {
+			continue
+		}
+		hc.addLocked(b.Addr, b.Weight, sourceDiscovery)
+	}
+	for _, server := range slices.Clone(hc.servers) {
+		if !keep[server] && hc.states[server].source == sourceDiscovery {
+			hc.removeLocked(server)
+		}
+	}
+}
+
+// report updates the state of the server with a check result.
+func (hc *healthChecker) report(server string, ok bool) {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	state, exists := hc.states[server]
+	if !exists {
+		return
+	}
+
+	if ok {
+		state.successes++
+		state.failures = 0
+		if !state.healthy && state.successes >= hc.rise {
+			state.healthy = true
+			hc.logger.Info("Backend is healthy again", "server", server)
+			hc.changed(server, state)
+		}
+	} else {
+		state.failures++
+		state.successes = 0
+		if state.healthy && state.failures >= hc.fall {
+			state.healthy = false
+			hc.logger.Warn("Backend is unhealthy", "server", server, "failures", state.failures)
+			hc.changed(server, state)
+		}
+	}
+}
+
+func (hc *healthChecker) changed(server string, state *healthState) {
+	if hc.onChange != nil {
+		hc.onChange(server, state.weight, state.available())
+	}
+}
+
+// watch sets the function notified when servers become available or not and
+// about weight changes, and calls it for the servers available at the moment.
+func (hc *healthChecker) watch(onChange func(server string, weight int, available bool)) {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	hc.onChange = onChange
+	for _, server := range hc.servers {
+		if state := hc.states[server]; state.available() {
+			onChange(server, state.weight, true)
+		}
+	}
+}
+
+// all returns all the servers of the pool.
+func (hc *healthChecker) all() []string {
+	hc.mu.RLock()
+	defer hc.mu.RUnlock()
+	return append([]string(nil), hc.servers...)
+}
+
+// healthy returns the servers that can receive requests.
+func (hc *healthChecker) healthy() []string {
+	hc.mu.RLock()
+	defer hc.mu.RUnlock()
+	var res []string
+	for _, server := range hc.servers {
+		if hc.states[server].available() {
+			res = append(res, server)
+		}
+	}
+	return res
+}
+
+// status returns the state of the server in the pool.
+func (hc *healthChecker) status(server string) (backendStatus, bool) {
+	hc.mu.RLock()
+	defer hc.mu.RUnlock()
+	state, exists := hc.states[server]
+	if !exists {
+		return backendStatus{}, false
+	}
+	return backendStatus{Addr: server, Weight: state.weight, Healthy: state.healthy, Mode: state.mode, Source: state.source}, true
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHealthChecker_Thresholds(t *testing.T) {
+	servers := []string{"a", "b", "c"}
+	hc := newHealthChecker(servers, nil, time.Second, 2, 3, slog.Default())
+	if healthy := hc.healthy(); !reflect.DeepEqual(healthy, servers) {
+		t.Fatalf("Expected all servers to be healthy initially, got %v", healthy)
+	}
+
+	// Fewer failures than the threshold keep the backend
+	hc.report("b", false)
+	hc.report("b", false)
+	hc.report("b", true)
+	hc.report("b", false)
+	hc.report("b", false)
+	if healthy := hc.healthy(); len(healthy) != 3 {
+		t.Errorf("Expected b to stay healthy, got %v", healthy)
+	}
+	hc.report("b", false)
+	if healthy := hc.healthy(); !reflect.DeepEqual(healthy, []string{"a", "c"}) {
+		t.Errorf("Expected b to be removed, got %v", healthy)
+	}
+
+	// Recovered backend is re-admitted after enough successful checks
+	hc.report("b", true)
+	if healthy := hc.healthy(); len(healthy) != 2 {
+		t.Errorf("Expected b to need more checks, got %v", healthy)
+	}
+	hc.report("b", true)
+	if healthy := hc.healthy(); !reflect.DeepEqual(healthy, servers) {
+		t.Errorf("Expected b to be re-admitted, got %v", healthy)
+	}
+
+	hc.report("unknown", false)
+	if healthy := hc.healthy(); len(healthy) != 3 {
+		t.Errorf("Unexpected servers %v", healthy)
+	}
+}
+
+func TestHealthChecker_Run(t *testing.T) {
+	var up atomic.Bool
+	check := func(server string) bool {
+		return server == "stable" || up.Load()
+	}
+	hc := newHealthChecker([]string{"stable", "flaky"}, check, 5*time.Millisecond, 1, 1, slog.Default())
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		hc.run(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	waitFor := func(expected int) {
+		t.Helper()
+		for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+			if len(hc.healthy()) == expected {
+				return
+			}
+		}
+		t.Fatalf("Expected %d healthy servers, got %v", expected, hc.healthy())
+	}
+	waitFor(1)
+	up.Store(true)
+	waitFor(2)
+}
+
+func TestBalancer_NoHealthyBackends(t *testing.T) {
+	hc := newHealthChecker([]string{"a"}, nil, time.Second, 1, 1, slog.Default())
+	hc.report("a", false)
+
+	rw := httptest.NewRecorder()
+	strategy := new(roundRobin)
+	attach(hc, strategy)
+	balancer(strategy, newPoolStats()).ServeHTTP(rw, httptest.NewRequest("GET", "/api/v1/some-data", nil))
+	if rw.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 without healthy backends, got %d", rw.Code)
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ringPoint is a virtual node of a server on the hash ring.
+type ringPoint struct {
+	hash   uint32
+	server string
+}
+
+// hashRing maps keys to servers with consistent hashing: every server owns
+// the arcs before its virtual nodes, so adding or removing a server only
+// remaps the keys of the arcs it gains or loses. A server gets
+// replicas*weight virtual nodes.
+type hashRing struct {
+	replicas int
+
+	mu      sync.RWMutex
+	points  []ringPoint // Sorted by hash
+	servers map[string]int
+}
+
+func newHashRing(replicas int) *hashRing {
+	return &hashRing{
+		replicas: max(replicas, 1),
+		servers:  make(map[string]int),
+	}
+}
+
+// hash32 spreads similar strings like virtual node names evenly over the ring.
+func hash32(s string) uint32 {
+	sum := sha1.Sum([]byte(s))
+	return binary.BigEndian.Uint32(sum[:])
+}
+
+// add puts the server on the ring, an existing server gets the new weight.
+func (r *hashRing) add(server string, weight int) {
+	weight = max(weight, 1)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.servers[server] == weight {
+		return
+	}
+	r.removeLocked(server)
+	r.servers[server] = weight
+	for i := 0; i < r.replicas*weight; i++ {
+		r.points = append(r.points, ringPoint{hash: hash32(server + "#" + strconv.Itoa(i)), server: server})
+	}
+	sort.Slice(r.points, func(i, j int) bool {
+		if r.points[i].hash != r.points[j].hash {
+			return r.points[i].hash < r.points[j].hash
+		}
+		return r.points[i].server < r.points[j].server
+	})
+}
+
+// remove takes the server off the ring.
+func (r *hashRing) remove(server string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.removeLocked(server)
+}
+
+func (r *hashRing) removeLocked(server string) {
+	if _, ok := r.servers[server]; !ok {
+		return
+	}
+	delete(r.servers, server)
+	points := r.points[:0]
+	for _, p := range r.points {
+		if p.server != server {
+			points = append(points, p)
+		}
+	}
+	r.points = points
+}
+
+// get returns the server owning the key, empty if the ring is empty.
+func (r *hashRing) get(key string) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if len(r.points) == 0 {
+		return ""
+	}
+	h := hash32(key)
+	i := sort.Search(len(r.points), func(i int) bool {
+		return r.points[i].hash >= h
+	})
+	if i == len(r.points) {
+		i = 0 // Wrap around the ring
+	}
+	return r.points[i].server
+}
+
+// maxWeight bounds the weight of a backend, every unit of it takes replicas virtual nodes.
+const maxWeight = 100
+
+// parseWeights parses a list like "server1:8080=2,server2:8080=1".
+func parseWeights(s string) (map[string]int, error) {
+	weights := make(map[string]int)
+	if s == "" {
+		return weights, nil
+	}
+	for _, item := range strings.Split(s, ",") {
+		server, value, ok := strings.Cut(strings.TrimSpace(item), "=")
+		weight, err := strconv.Atoi(value)
+		if !ok || server == "" || err != nil || weight <= 0 || weight > maxWeight {
+			return nil, fmt.Errorf("bad server weight %q, expected server=weight with weight up to %d", item, maxWeight)
+		}
+		weights[server] = weight
+	}
+	return weights, nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"log/slog"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func assign(ring *hashRing, keys int) map[string]string {
+	res := make(map[string]string, keys)
+	for i := 0; i < keys; i++ {
+		key := "/path/" + strconv.Itoa(i)
+		res[key] = ring.get(key)
+	}
+	return res
+}
+
+func TestHashRing_Remap(t *testing.T) {
+	ring := newHashRing(100)
+	if server := ring.get("/path"); server != "" {
+		t.Errorf("Expected no server on empty ring, got %s", server)
+	}
+	for _, server := range []string{"server1:8080", "server2:8080", "server3:8080"} {
+		ring.add(server, 1)
+	}
+	before := assign(ring, 1000)
+
+	counts := make(map[string]int)
+	for _, server := range before {
+		counts[server]++
+	}
+	for server, count := range counts {
+		if count < 200 {
+			t.Errorf("Server %s got only %d of 1000 keys", server, count)
+		}
+	}
+
+	// Only keys of the removed server move
+	ring.remove("server2:8080")
+	after := assign(ring, 1000)
+	for key, server := range before {
+		if server != "server2:8080" && after[key] != server {
+			t.Errorf("Key %s moved from %s to %s", key, server, after[key])
+		}
+		if after[key] == "server2:8080" {
+			t.Errorf("Key %s is still on the removed server", key)
+		}
+	}
+
+	// Only keys taken by the added server move
+	ring.add("server4:8080", 1)
+	added := assign(ring, 1000)
+	for key, server := range after {
+		if added[key] != server && added[key] != "server4:8080" {
+			t.Errorf("Key %s moved from %s to %s", key, server, added[key])
+		}
+	}
+}
+
+func TestHashRing_Weights(t *testing.T) {
+	ring := newHashRing(50)
+	ring.add("heavy", 3)
+	ring.add("light", 1)
+	counts := make(map[string]int)
+	for _, server := range assign(ring, 4000) {
+		counts[server]++
+	}
+	if counts["heavy"] < 2*counts["light"] {
+		t.Errorf("Expected heavy server to get about 3x more keys, got %v", counts)
+	}
+
+	// Changing the weight replaces the virtual nodes
+	ring.add("heavy", 1)
+	if len(ring.points) != 100 {
+		t.Errorf("Expected 100 virtual nodes, got %d", len(ring.points))
+	}
+}
+
+func TestHashStrategy_Health(t *testing.T) {
+	hc := newHealthChecker([]string{"a", "b"}, nil, time.Second, 1, 1, slog.Default())
+	hc.add("a", 2)
+	ring := newHashRing(10)
+	attach(hc, &hashStrategy{ring: ring})
+	if len(ring.points) != 30 {
+		t.Errorf("Expected 30 virtual nodes, got %d", len(ring.points))
+	}
+	hc.report("a", false)
+	for _, server := range assign(ring, 100) {
+		if server != "b" {
+			t.Fatalf("Expected only b on the ring, got %s", server)
+		}
+	}
+	hc.report("a", true)
+	if len(ring.points) != 30 {
+		t.Errorf("Expected a to be back on the ring, got %d virtual nodes", len(ring.points))
+	}
+}
+
+func TestParseWeights(t *testing.T) {
+	weights, err := parseWeights("server1:8080=2, server2:8080=1")
+	if err != nil || weights["server1:8080"] != 2 || weights["server2:8080"] != 1 {
+		t.Errorf("Bad weights: %v, %v", weights, err)
+	}
+	for _, s := range []string{"server1", "server1=0", "server1=101", "=2", "server1=x"} {
+		if _, err := parseWeights(s); err == nil {
+			t.Errorf("Expected error for %q", s)
+		}
+	}
+}
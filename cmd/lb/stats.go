@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// backendStats counts the requests forwarded to a backend.
+type backendStats struct {
+	active     int // Requests in progress
+	requests   int // Finished requests
+	errors     int // Finished requests that failed or got a 5xx response
+	latency    time.Duration
+	maxLatency time.Duration
+}
+
+// poolStats keeps the stats of the backends that received requests.
+type poolStats struct {
+	mu       sync.Mutex
+	backends map[string]*backendStats
+}
+
+func newPoolStats() *poolStats {
+	return &poolStats{backends: make(map[string]*backendStats)}
+}
+
+// start accounts a new request to the server and returns the function
+// to call once it is finished.
+func (p *poolStats) start(server string) func(failed bool) {
+	p.mu.Lock()
+	stats, exists := p.backends[server]
+	if !exists {
+		stats = new(backendStats)
+		p.backends[server] = stats
+	}
+	stats.active++
+	p.mu.Unlock()
+
+	started := time.Now()
+	return func(failed bool) {
+		elapsed := time.Since(started)
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		stats.active--
+		stats.requests++
+		if failed {
+			stats.errors++
+		}
+		stats.latency += elapsed
+		stats.maxLatency = max(stats.maxLatency, elapsed)
+	}
+}
+
+// get returns a copy of the server stats.
+func (p *poolStats) get(server string) backendStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if stats, exists := p.backends[server]; exists {
+		return *stats
+	}
+	return backendStats{}
+}
+
+// statusWriter remembers the status code of the response.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
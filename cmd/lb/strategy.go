@@ -0,0 +1,259 @@
+package main
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Strategy chooses a backend for every request among the available ones.
+type Strategy interface {
+	// Add makes the server available, an existing server gets the new weight.
+	Add(server string, weight int)
+	// Remove stops sending requests to the server.
+	Remove(server string)
+	// Select returns the server for the request, empty if there are none.
+	// Done must be called once the request is finished.
+	Select(r *http.Request) (server string, done func())
+}
+
+// Names of the strategies accepted by newStrategy.
+const (
+	strategyRoundRobin     = "round-robin"
+	strategyLeastConn      = "least-conn"
+	strategyWeightedRandom = "weighted-random"
+	strategyPowerOfTwo     = "p2c"
+	strategyHash           = "hash"
+)
+
+// newStrategy creates a strategy by its name. The hash strategy uses
+// hashKey to get the key from a request and the ring with the given
+// number of virtual nodes per server.
+func newStrategy(name, hashKey string, replicas int) (Strategy, error) {
+	switch name {
+	case strategyRoundRobin:
+		return new(roundRobin), nil
+	case strategyLeastConn:
+		return &leastConn{conns: newConnCounter()}, nil
+	case strategyWeightedRandom:
+		return new(weightedRandom), nil
+	case strategyPowerOfTwo:
+		return &powerOfTwo{conns: newConnCounter()}, nil
+	case strategyHash:
+		key, err := requestKey(hashKey)
+		if err != nil {
+			return nil, err
+		}
+		return &hashStrategy{ring: newHashRing(replicas), key: key}, nil
+	}
+	return nil, fmt.Errorf("unknown balancing strategy %q", name)
+}
+
+func noop() {}
+
+// serverList keeps the available servers with their weights in the order they were added.
+type serverList struct {
+	mu      sync.RWMutex
+	servers []string
+	weights map[string]int
+}
+
+func (l *serverList) Add(server string, weight int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.weights == nil {
+		l.weights = make(map[string]int)
+	}
+	if _, ok := l.weights[server]; !ok {
+		l.servers = append(l.servers, server)
+	}
+	l.weights[server] = max(weight, 1)
+}
+
+func (l *serverList) Remove(server string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if i := slices.Index(l.servers, server); i >= 0 {
+		l.servers = slices.Delete(l.servers, i, i+1)
+		delete(l.weights, server)
+	}
+}
+
+// roundRobin sends requests to the servers in turn.
+type roundRobin struct {
+	serverList
+	next atomic.Uint64
+}
+
+func (s *roundRobin) Select(*http.Request) (string, func()) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if len(s.servers) == 0 {
+		return "", noop
+	}
+	return s.servers[(s.next.Add(1)-1)%uint64(len(s.servers))], noop
+}
+
+// weightedRandom picks a random server with probability proportional to its weight.
+type weightedRandom struct {
+	serverList
+}
+
+func (s *weightedRandom) Select(*http.Request) (string, func()) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	total := 0
+	for _, server := range s.servers {
+		total += s.weights[server]
+	}
+	if total == 0 {
+		return "", noop
+	}
+	n := rand.IntN(total)
+	for _, server := range s.servers {
+		if n -= s.weights[server]; n < 0 {
+			return server, noop
+		}
+	}
+	return "", noop
+}
+
+// connCounter tracks requests in progress for every server.
+type connCounter struct {
+	mu     sync.Mutex
+	active map[string]int
+}
+
+func newConnCounter() *connCounter {
+	return &connCounter{active: make(map[string]int)}
+}
+
+func (c *connCounter) count(server string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.active[server]
+}
+
+// start accounts a new request to the server and returns its done function.
+func (c *connCounter) start(server string) func() {
+	c.mu.Lock()
+	c.active[server]++
+	c.mu.Unlock()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			if c.active[server]--; c.active[server] <= 0 {
+				delete(c.active, server)
+			}
+		})
+	}
+}
+
+// leastConn sends requests to the server with the fewest requests in progress.
+type leastConn struct {
+	serverList
+	conns *connCounter
+}
+
+func (s *leastConn) Select(*http.Request) (string, func()) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if len(s.servers) == 0 {
+		return "", noop
+	}
+	// Selection and accounting are not atomic, which is fine for balancing
+	best, bestCount := "", 0
+	for _, server := range s.servers {
+		if count := s.conns.count(server); best == "" || count < bestCount {
+			best, bestCount = server, count
+		}
+	}
+	return best, s.conns.start(best)
+}
+
+// powerOfTwo picks two random servers and sends the request to the less busy one.
+type powerOfTwo struct {
+	serverList
+	conns *connCounter
+}
+
+func (s *powerOfTwo) Select(*http.Request) (string, func()) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	switch len(s.servers) {
+	case 0:
+		return "", noop
+	case 1:
+		return s.servers[0], s.conns.start(s.servers[0])
+	}
+	i := rand.IntN(len(s.servers))
+	j := rand.IntN(len(s.servers) - 1)
+	if j >= i {
+		j++ // Two different servers
+	}
+	server := s.servers[i]
+	if s.conns.count(s.servers[j]) < s.conns.count(server) {
+		server = s.servers[j]
+	}
+	return server, s.conns.start(server)
+}
+
+// hashStrategy sends requests with the same key to the same server using the hash ring.
+type hashStrategy struct {
+	ring *hashRing
+	key  func(r *http.Request) string
+}
+
+func (s *hashStrategy) Add(server string, weight int) {
+	s.ring.add(server, weight)
+}
+
+func (s *hashStrategy) Remove(server string) {
+	s.ring.remove(server)
+}
+
+func (s *hashStrategy) Select(r *http.Request) (string, func()) {
+	return s.ring.get(s.key(r)), noop
+}
+
+// requestKey parses the source of the hash key: path, ip, header:<name> or cookie:<name>.
+// Requests without the header or cookie are hashed by the path.
+func requestKey(spec string) (func(r *http.Request) string, error) {
+	kind, name, _ := strings.Cut(spec, ":")
+	switch {
+	case spec == "path":
+		return func(r *http.Request) string { return r.URL.Path }, nil
+	case spec == "ip":
+		return clientIP, nil
+	case kind == "header" && name != "":
+		return func(r *http.Request) string {
+			if value := r.Header.Get(name); value != "" {
+				return value
+			}
+			return r.URL.Path
+		}, nil
+	case kind == "cookie" && name != "":
+		return func(r *http.Request) string {
+			if cookie, err := r.Cookie(name); err == nil && cookie.Value != "" {
+				return cookie.Value
+			}
+			return r.URL.Path
+		}, nil
+	}
+	return nil, fmt.Errorf("bad hash key %q, expected path, ip, header:<name> or cookie:<name>", spec)
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func selectN(s Strategy, n int) map[string]int {
+	counts := make(map[string]int)
+	for i := 0; i < n; i++ {
+		server, done := s.Select(httptest.NewRequest("GET", "/", nil))
+		counts[server]++
+		done()
+	}
+	return counts
+}
+
+func TestNewStrategy(t *testing.T) {
+	for _, name := range []string{strategyRoundRobin, strategyLeastConn, strategyWeightedRandom, strategyPowerOfTwo, strategyHash} {
+		s, err := newStrategy(name, "path", 10)
+		if err != nil {
+			t.Fatalf("Cannot create %s: %s", name, err)
+		}
+		if server, done := s.Select(httptest.NewRequest("GET", "/", nil)); server != "" {
+			t.Errorf("Expected no server from empty %s, got %s", name, server)
+		} else {
+			done()
+		}
+		s.Add("a", 1)
+		s.Add("b", 1)
+		s.Remove("a")
+		if counts := selectN(s, 10); counts["b"] != 10 {
+			t.Errorf("Expected %s to select only b, got %v", name, counts)
+		}
+	}
+	if _, err := newStrategy("random", "path", 10); err == nil {
+		t.Error("Expected error for unknown strategy")
+	}
+	if _, err := newStrategy(strategyHash, "query", 10); err == nil {
+		t.Error("Expected error for unknown hash key")
+	}
+}
+
+func TestRoundRobin(t *testing.T) {
+	s := new(roundRobin)
+	s.Add("a", 1)
+	s.Add("b", 1)
+	s.Add("c", 1)
+	var order []string
+	for i := 0; i < 6; i++ {
+		server, _ := s.Select(nil)
+		order = append(order, server)
+	}
+	for i, server := range order {
+		if expected := []string{"a", "b", "c"}[i%3]; server != expected {
+			t.Errorf("Expected %s at %d, got %v", expected, i, order)
+		}
+	}
+}
+
+func TestLeastConn(t *testing.T) {
+	s := &leastConn{conns: newConnCounter()}
+	s.Add("a", 1)
+	s.Add("b", 1)
+
+	first, doneFirst := s.Select(nil)
+	second, doneSecond := s.Select(nil)
+	if first == second {
+		t.Errorf("Expected requests to go to different servers, got %s twice", first)
+	}
+	doneSecond()
+	doneSecond() // Done is idempotent
+	if server, done := s.Select(nil); server != second {
+		t.Errorf("Expected idle %s, got %s", second, server)
+	} else {
+		done()
+	}
+	doneFirst()
+	if s.conns.count(first) != 0 || s.conns.count(second) != 0 {
+		t.Errorf("Expected no active requests")
+	}
+}
+
+func TestPowerOfTwo(t *testing.T) {
+	s := &powerOfTwo{conns: newConnCounter()}
+	s.Add("busy", 1)
+	s.Add("idle", 1)
+	done := s.conns.start("busy")
+	defer done()
+	if counts := selectN(s, 20); counts["idle"] != 20 {
+		t.Errorf("Expected all requests to go to the idle server, got %v", counts)
+	}
+}
+
+func TestWeightedRandom(t *testing.T) {
+	s := new(weightedRandom)
+	s.Add("heavy", 4)
+	s.Add("light", 1)
+	counts := selectN(s, 5000)
+	if counts["heavy"] < 3*counts["light"] || counts["light"] == 0 {
+		t.Errorf("Expected about 4x more requests to heavy, got %v", counts)
+	}
+}
+
+func TestRequestKey(t *testing.T) {
+	req := httptest.NewRequest("GET", "/some/path", nil)
+	req.RemoteAddr = "10.0.0.1:5000"
+	req.Header.Set("X-User", "user1")
+	req.AddCookie(&http.Cookie{Name: "session", Value: "abc"})
+
+	for spec, expected := range map[string]string{
+		"path":           "/some/path",
+		"ip":             "10.0.0.1",
+		"header:X-User":  "user1",
+		"header:Missing": "/some/path",
+		"cookie:session": "abc",
+		"cookie:missing": "/some/path",
+	} {
+		key, err := requestKey(spec)
+		if err != nil {
+			t.Fatalf("Cannot parse %s: %s", spec, err)
+		}
+		if value := key(req); value != expected {
+			t.Errorf("Expected %s for %s, got %s", expected, spec, value)
+		}
+	}
+	for _, spec := range []string{"", "header", "cookie:", "query:x"} {
+		if _, err := requestKey(spec); err == nil {
+			t.Errorf("Expected error for %q", spec)
+		}
+	}
+}
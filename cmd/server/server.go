@@ -1,18 +1,28 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"flag"
+	"log/slog"
 	"net/http"
 	"os"
 	"strconv"
 	"time"
 
+	"github.com/roman-mazur/architecture-practice-4-template/dbclient"
 	"github.com/roman-mazur/architecture-practice-4-template/httptools"
+	"github.com/roman-mazur/architecture-practice-4-template/logging"
 	"github.com/roman-mazur/architecture-practice-4-template/signal"
 )
 
-var port = flag.Int("port", 8080, "server port") // Define a flag for server port
+var (
+	port      = flag.Int("port", 8080, "server port") // Define a flag for server port
+	dbAddress = flag.String("db", "http://db:8091", "address of the db service")
+	seedKey   = flag.String("seed-key", "lb5", "key of the record created on startup (team name)")
+	logConfig = logging.BindFlags(flag.CommandLine)
+)
 
 // Constants for configuration keys
 const (
@@ -20,7 +30,17 @@ const (
 	confHealthFailure    = "CONF_HEALTH_FAILURE"
 )
 
+// Seeding is retried since the db service may start after the server
+const (
+	seedAttempts = 10
+	seedDelay    = time.Second
+)
+
 func main() {
+	flag.Parse()
+	logger := logging.Component(logging.New(*logConfig), "server")
+	slog.SetDefault(logger)
+
 	// Initialize HTTP server mux
 	h := new(http.ServeMux)
 
@@ -37,11 +57,30 @@ func main() {
 		}
 	})
 
-	// Initialize report
+	// Initialize report and the db client
 	report := make(Report)
+	db := dbclient.New(*dbAddress)
 
 	// Handle API endpoint for processing some data
-	h.HandleFunc("/api/v1/some-data", func(rw http.ResponseWriter, r *http.Request) {
+	h.Handle("/api/v1/some-data", dataHandler(db, report))
+
+	// Mount report handler
+	h.Handle("/report", report)
+
+	// Create and start HTTP server
+	server := httptools.CreateServer(*port, h, httptools.WithLogger(logger))
+	server.Start()
+
+	// Store the team record with the current date
+	go seed(db, *seedKey, time.Now().Format(time.DateOnly))
+
+	// Wait for termination signal
+	signal.WaitForTerminationSignal()
+}
+
+// dataHandler returns the value of the key query parameter read from the db.
+func dataHandler(db *dbclient.Client, report Report) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
 		// Get response delay configuration
 		respDelayString := os.Getenv(confResponseDelaySec)
 		// Parse response delay and sleep if valid
@@ -52,20 +91,42 @@ func main() {
 		// Process the request and update the report
 		report.Process(r)
 
+		key := r.URL.Query().Get("key")
+		if key == "" {
+			http.Error(rw, "key is not defined", http.StatusBadRequest)
+			return
+		}
+		value, err := db.Get(r.Context(), key)
+		if errors.Is(err, dbclient.ErrNotFound) {
+			http.Error(rw, "key not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			slog.Error("Cannot read from the db", "key", key, "err", err)
+			http.Error(rw, "db is not available", http.StatusBadGateway)
+			return
+		}
+
 		// Set response headers and status
 		rw.Header().Set("content-type", "application/json")
 		rw.WriteHeader(http.StatusOK)
 		// Encode response data as JSON and write to response writer
-		_ = json.NewEncoder(rw).Encode([]string{"1", "2"})
-	})
-
-	// Mount report handler
-	h.Handle("/report", report)
-
-	// Create and start HTTP server
-	server := httptools.CreateServer(*port, h)
-	server.Start()
+		_ = json.NewEncoder(rw).Encode(map[string]string{"key": key, "value": value})
+	}
+}
 
-	// Wait for termination signal
-	signal.WaitForTerminationSignal()
+// seed stores the record in the db retrying while the db is not available.
+func seed(db *dbclient.Client, key, value string) {
+	for attempt := 1; ; attempt++ {
+		err := db.Put(context.Background(), key, value)
+		if err == nil {
+			slog.Info("Seeded the db", "key", key, "value", value)
+			return
+		}
+		if attempt == seedAttempts {
+			slog.Error("Cannot seed the db", "key", key, "err", err)
+			return
+		}
+		slog.Warn("Cannot seed the db, retrying", "key", key, "attempt", attempt, "err", err)
+		time.Sleep(seedDelay)
+	}
 }
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/roman-mazur/architecture-practice-4-template/dbclient"
+)
+
+func TestDataHandler(t *testing.T) {
+	dbServer := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/db/lb5":
+			_ = json.NewEncoder(rw).Encode(map[string]string{"key": "lb5", "value": "2024-05-01"})
+		case "/db/broken":
+			http.Error(rw, "failure", http.StatusInternalServerError)
+		default:
+			http.Error(rw, "key not found", http.StatusNotFound)
+		}
+	}))
+	defer dbServer.Close()
+	handler := dataHandler(dbclient.New(dbServer.URL), make(Report))
+
+	for query, status := range map[string]int{
+		"?key=lb5":     http.StatusOK,
+		"?key=missing": http.StatusNotFound,
+		"?key=broken":  http.StatusBadGateway,
+		"":             http.StatusBadRequest,
+	} {
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, httptest.NewRequest("GET", "/api/v1/some-data"+query, nil))
+		if rw.Code != status {
+			t.Errorf("Expected %d for %q, got %d", status, query, rw.Code)
+		}
+	}
+
+	rw := httptest.NewRecorder()
+	handler.ServeHTTP(rw, httptest.NewRequest("GET", "/api/v1/some-data?key=lb5", nil))
+	var body map[string]string
+	if err := json.NewDecoder(rw.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body["value"] != "2024-05-01" {
+		t.Errorf("Bad response %v", body)
+	}
+}
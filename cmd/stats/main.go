@@ -6,19 +6,51 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"strings"
 	"time"
 )
 
 var https = flag.Bool("https", false, "whether backends support HTTPs")
+var servers = flag.String("servers", "", "comma-separated backends to query instead of the balancer pool")
+var adminURL = flag.String("admin", "http://localhost:8095", "admin API of the balancer listing the backends")
+var adminToken = flag.String("admin-token", os.Getenv("LB_ADMIN_TOKEN"), "bearer token of the balancer admin API, defaults to $LB_ADMIN_TOKEN")
 
-var serversPool = []string{
-	"localhost:8080",
-	"localhost:8081",
-	"localhost:8082",
-}
+// serversPool holds the backends to query, by default the current pool of the balancer.
+var serversPool []string
 
 type report map[string][]string
 
+// balancerPool lists the backends of the balancer through its admin API.
+func balancerPool(client *http.Client) ([]string, error) {
+	req, err := http.NewRequest("GET", strings.TrimSuffix(*adminURL, "/")+"/backends", nil)
+	if err != nil {
+		return nil, err
+	}
+	if *adminToken != "" {
+		req.Header.Set("authorization", "Bearer "+*adminToken)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("admin API responded with status %d", resp.StatusCode)
+	}
+	var backends []struct {
+		Addr string `json:"addr"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&backends); err != nil {
+		return nil, err
+	}
+	res := make([]string, len(backends))
+	for i, b := range backends {
+		res[i] = b.Addr
+	}
+	return res, nil
+}
+
 func scheme() string {
 	if *https {
 		return "https"
@@ -32,6 +64,16 @@ func main()  {
 	client := new(http.Client)
 	client.Timeout = 10 * time.Second
 
+	if *servers != "" {
+		serversPool = strings.Split(*servers, ",")
+	} else {
+		pool, err := balancerPool(client)
+		if err != nil {
+			log.Fatalf("cannot get backends from the balancer: %s", err)
+		}
+		serversPool = pool
+	}
+
 	res := make([]report, len(serversPool))
 	for i, s := range serversPool {
 		resp, err := client.Get(fmt.Sprintf("%s://%s/report", scheme(), s))
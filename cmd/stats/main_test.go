@@ -12,7 +12,7 @@ import (
 )
 
 func TestMain(t *testing.T) {
-	servers := make([]*httptest.Server, len(serversPool))
+	servers := make([]*httptest.Server, 3)
 	for i := range servers {
 		servers[i] = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			data := report{
@@ -24,11 +24,20 @@ func TestMain(t *testing.T) {
 		defer servers[i].Close()
 	}
 
-	originalServersPool := serversPool
-	for i, server := range servers {
-		serversPool[i] = server.Listener.Addr().String()
-	}
-	defer func() { serversPool = originalServersPool }()
+	// The pool is read from the admin API of the balancer
+	admin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/backends" || r.Header.Get("Authorization") != "Bearer secret" {
+			http.NotFound(w, r)
+			return
+		}
+		backends := make([]map[string]string, len(servers))
+		for i, server := range servers {
+			backends[i] = map[string]string{"addr": server.Listener.Addr().String()}
+		}
+		json.NewEncoder(w).Encode(backends)
+	}))
+	defer admin.Close()
+	*adminURL, *adminToken = admin.URL, "secret"
 
 	var logBuf bytes.Buffer
 	log.SetOutput(&logBuf)
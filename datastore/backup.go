@@ -0,0 +1,182 @@
+package datastore
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Backup stream layout: magic | record... | end marker | record count.
+// Records use the segment format, the end marker is a zero record size.
+const backupMagic = "DSBACKUP"
+
+// Backup writes a consistent copy of the live data to w.
+// Only the current record of every key is written. Writes to the database
+// are not blocked while the backup runs.
+func (db *Db) Backup(w io.Writer) error {
+	out := bufio.NewWriterSize(w, bufSize)
+	if _, err := out.WriteString(backupMagic); err != nil {
+		return err
+	}
+	count, err := db.backupRecords(out)
+	if err != nil {
+		return err
+	}
+	var footer [12]byte
+	binary.LittleEndian.PutUint64(footer[4:], count)
+	if _, err := out.Write(footer[:]); err != nil {
+		return err
+	}
+	return out.Flush()
+}
+
+// BackupToDir writes a copy of the live data into a new database directory.
+func (db *Db) BackupToDir(dir string) error {
+	return createDbDir(dir, db.fileMode, func(w io.Writer) error {
+		_, err := db.backupRecords(w)
+		return err
+	})
+}
+
+// backupRecords copies records of all the keys from a snapshot to w.
+func (db *Db) backupRecords(w io.Writer) (uint64, error) {
+	snapshot, err := db.Snapshot()
+	if err != nil {
+		return 0, err
+	}
+	defer snapshot.Close()
+
+	it, err := snapshot.Scan("", "")
+	if err != nil {
+		return 0, err
+	}
+	defer it.Close()
+
+	var count uint64
+	for it.Next() {
+		position := it.items[it.current].position
+		data, err := position.segment.readRecord(position.offset)
+		if err != nil {
+			return count, err
+		}
+		var e entry
+		if err := e.Decode(data); err != nil {
+			return count, position.segment.corrupted(position.offset, err)
+		}
+		if _, err := w.Write(data); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+// Restore creates a database directory from a stream written by Db.Backup.
+// The directory must not contain a database.
+func Restore(r io.Reader, dir string) error {
+	in := bufio.NewReaderSize(r, bufSize)
+	if err := readBackupMagic(in); err != nil {
+		return err
+	}
+	return createDbDir(dir, 0o600, func(w io.Writer) error {
+		return readBackupRecords(in, w)
+	})
+}
+
+// VerifyBackup reads a stream written by Db.Backup checking that it is complete
+// and all its records are intact.
+func VerifyBackup(r io.Reader) error {
+	in := bufio.NewReaderSize(r, bufSize)
+	if err := readBackupMagic(in); err != nil {
+		return err
+	}
+	return readBackupRecords(in, io.Discard)
+}
+
+func readBackupMagic(in io.Reader) error {
+	magic := make([]byte, len(backupMagic))
+	if _, err := io.ReadFull(in, magic); err != nil || string(magic) != backupMagic {
+		return fmt.Errorf("not a database backup")
+	}
+	return nil
+}
+
+// readBackupRecords copies the records of the backup to w up to the end marker
+// and checks their count.
+func readBackupRecords(in io.Reader, w io.Writer) error {
+	var count uint64
+	for {
+		var header [4]byte
+		if _, err := io.ReadFull(in, header[:]); err != nil {
+			return fmt.Errorf("backup is truncated after %d records: %w", count, err)
+		}
+		size := binary.LittleEndian.Uint32(header[:])
+		if size == 0 {
+			break // End marker
+		}
+		if size < headerSize {
+			return fmt.Errorf("bad record size %d in backup", size)
+		}
+		data := make([]byte, size)
+		copy(data, header[:])
+		if _, err := io.ReadFull(in, data[4:]); err != nil {
+			return fmt.Errorf("backup is truncated after %d records: %w", count, err)
+		}
+		var e entry
+		if err := e.Decode(data); err != nil {
+			return fmt.Errorf("%w: record %d of backup: %s", ErrCorrupted, count, err)
+		}
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+		count++
+	}
+
+	var footer [8]byte
+	if _, err := io.ReadFull(in, footer[:]); err != nil {
+		return fmt.Errorf("backup is truncated: %w", err)
+	}
+	if expected := binary.LittleEndian.Uint64(footer[:]); expected != count {
+		return fmt.Errorf("backup has %d records, expected %d", count, expected)
+	}
+	return nil
+}
+
+// createDbDir creates a database directory with a single segment written by fill.
+func createDbDir(dir string, mode os.FileMode, fill func(w io.Writer) error) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	if ids, err := listSegments(dir); err != nil {
+		return err
+	} else if len(ids) > 0 {
+		return fmt.Errorf("database already exists in %s", dir)
+	}
+
+	path := segmentPath(dir, 0)
+	tempPath := path + compactingSuffix
+	f, err := os.OpenFile(tempPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode)
+	if err != nil {
+		return err
+	}
+	out := bufio.NewWriterSize(f, bufSize)
+	err = fill(out)
+	if err == nil {
+		err = out.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tempPath, path)
+	}
+	if err != nil {
+		os.Remove(tempPath)
+	}
+	return err
+}
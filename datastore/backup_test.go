@@ -0,0 +1,85 @@
+package datastore
+
+import (
+	"bytes"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestDb_BackupRestore(t *testing.T) {
+	db, err := NewDb(t.TempDir(), WithSegmentSize(200))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for i := 0; i < 50; i++ {
+		if err := db.Put("key"+strconv.Itoa(i%10), "value"+strconv.Itoa(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Delete("key0"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.PutInt64("counter", 5); err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(t *testing.T, dir string) {
+		restored, err := NewDb(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer restored.Close()
+		for i := 41; i < 50; i++ {
+			key := "key" + strconv.Itoa(i%10)
+			if value, err := restored.Get(key); err != nil || value != "value"+strconv.Itoa(i) {
+				t.Errorf("Bad value for %s: %s, %v", key, value, err)
+			}
+		}
+		if _, err := restored.Get("key0"); err != ErrNotFound {
+			t.Errorf("Expected ErrNotFound for deleted key, got %v", err)
+		}
+		if value, err := restored.GetInt64("counter"); err != nil || value != 5 {
+			t.Errorf("Bad value for counter: %d, %v", value, err)
+		}
+	}
+
+	t.Run("stream", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := db.Backup(&buf); err != nil {
+			t.Fatal(err)
+		}
+		dir := filepath.Join(t.TempDir(), "restored")
+		if err := Restore(bytes.NewReader(buf.Bytes()), dir); err != nil {
+			t.Fatal(err)
+		}
+		check(t, dir)
+
+		if err := Restore(bytes.NewReader(buf.Bytes()), dir); err == nil {
+			t.Error("Expected error restoring into existing database")
+		}
+		truncated := buf.Bytes()[:buf.Len()-20]
+		if err := Restore(bytes.NewReader(truncated), t.TempDir()); err == nil {
+			t.Error("Expected error restoring truncated backup")
+		}
+
+		if err := VerifyBackup(bytes.NewReader(buf.Bytes())); err != nil {
+			t.Errorf("Expected complete backup to pass verification, got %v", err)
+		}
+		for _, broken := range [][]byte{truncated, buf.Bytes()[:buf.Len()-12], buf.Bytes()[len(backupMagic):]} {
+			if err := VerifyBackup(bytes.NewReader(broken)); err == nil {
+				t.Errorf("Expected verification error for %d bytes of %d", len(broken), buf.Len())
+			}
+		}
+	})
+
+	t.Run("dir", func(t *testing.T) {
+		dir := t.TempDir()
+		if err := db.BackupToDir(dir); err != nil {
+			t.Fatal(err)
+		}
+		check(t, dir)
+	})
+}
@@ -0,0 +1,122 @@
+package datastore
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// A batch is stored as a single record flagged with flagBatch.
+// Its value holds the encoded records of the batch one after another,
+// so the whole batch passes or fails the checksum as a unit.
+const batchHeaderSize = headerSize + 8
+
+// Batch collects writes that Db.Write applies atomically.
+// The zero value is an empty batch ready to use.
+type Batch struct {
+	entries []entry
+}
+
+// Put adds writing a string value to the batch.
+func (b *Batch) Put(key, value string) {
+	b.entries = append(b.entries, entry{key: key, value: value})
+}
+
+// PutInt64 adds writing an integer value to the batch.
+func (b *Batch) PutInt64(key string, value int64) {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], uint64(value))
+	b.entries = append(b.entries, entry{key: key, value: string(buf[:]), valueType: typeInt64})
+}
+
+// PutBytes adds writing a binary value to the batch.
+func (b *Batch) PutBytes(key string, value []byte) {
+	b.entries = append(b.entries, entry{key: key, value: string(value), valueType: typeBytes})
+}
+
+// Delete adds deleting the key to the batch.
+func (b *Batch) Delete(key string) {
+	b.entries = append(b.entries, entry{key: key, flags: flagTombstone})
+}
+
+// Len returns the number of operations in the batch.
+func (b *Batch) Len() int {
+	return len(b.entries)
+}
+
+// Reset clears the batch so it can be reused.
+func (b *Batch) Reset() {
+	b.entries = b.entries[:0]
+}
+
+// Write applies all the operations of the batch in order.
+// After a crash either all of them or none are recovered.
+func (db *Db) Write(b *Batch) error {
+	if b.Len() == 0 {
+		return nil
+	}
+
+	for i := range b.entries {
+		b.entries[i].compress(db.compressThreshold)
+	}
+
+	db.mu.Lock()
+	if db.segments == nil {
+		db.mu.Unlock()
+		return ErrClosed
+	}
+	// Versions are assigned in the write order, so records are encoded under the lock
+	records := make([][]byte, len(b.entries))
+	var value []byte
+	for i := range b.entries {
+		e := &b.entries[i]
+		e.version = db.nextVersion()
+		records[i] = e.Encode()
+		value = append(value, records[i]...)
+	}
+	frame := entry{value: string(value), flags: flagBatch}
+
+	offset, err := db.append(frame.Encode())
+	if err == nil {
+		offset += batchHeaderSize
+		for i := range b.entries {
+			db.apply(&b.entries[i], db.activeSegment(), offset, int64(len(records[i])))
+			offset += int64(len(records[i]))
+		}
+	}
+	err = db.rotateIfFull(err)
+	seq := db.writeSeq
+	db.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+	return db.waitDurable(seq)
+}
+
+// scanBatch passes records of the batch stored at the given offset to fn.
+func (s *segment) scanBatch(frame *entry, offset int64, fn func(e *entry, offset int64, data []byte) error) error {
+	body := []byte(frame.value)
+	offset += batchHeaderSize
+	for len(body) > 0 {
+		if len(body) < 4 {
+			return s.corrupted(offset, fmt.Errorf("bad batch record"))
+		}
+		size := int(binary.LittleEndian.Uint32(body))
+		if size > len(body) {
+			return s.corrupted(offset, fmt.Errorf("bad batch record size"))
+		}
+		var e entry
+		if err := e.Decode(body[:size]); err != nil {
+			return s.corrupted(offset, err)
+		}
+		if e.flags&flagBatch != 0 {
+			return s.corrupted(offset, fmt.Errorf("nested batch"))
+		}
+		if err := fn(&e, offset, body[:size]); err != nil {
+			return err
+		}
+		body = body[size:]
+		offset += int64(size)
+	}
+	return nil
+}
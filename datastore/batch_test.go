@@ -0,0 +1,80 @@
+package datastore
+
+import (
+	"os"
+	"testing"
+)
+
+func TestDb_Write(t *testing.T) {
+	dir := t.TempDir()
+	db, err := NewDb(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put("old", "value"); err != nil {
+		t.Fatal(err)
+	}
+
+	var b Batch
+	b.Put("object", "data")
+	b.Put("lookup", "object")
+	b.PutInt64("count", 1)
+	b.Delete("old")
+	if err := db.Write(&b); err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(db *Db) {
+		if value, err := db.Get("object"); err != nil || value != "data" {
+			t.Errorf("Bad value for object: %s, %v", value, err)
+		}
+		if value, err := db.Get("lookup"); err != nil || value != "object" {
+			t.Errorf("Bad value for lookup: %s, %v", value, err)
+		}
+		if value, err := db.GetInt64("count"); err != nil || value != 1 {
+			t.Errorf("Bad value for count: %d, %v", value, err)
+		}
+		if _, err := db.Get("old"); err != ErrNotFound {
+			t.Errorf("Expected ErrNotFound for deleted key, got %v", err)
+		}
+	}
+	check(db)
+
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db, err = NewDb(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(db)
+	if err := db.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	check(db)
+
+	// A batch torn by a crash is dropped completely
+	b.Reset()
+	b.Put("object", "new data")
+	b.Put("lookup", "new object")
+	if err := db.Write(&b); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	path := segmentPath(dir, 0)
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(path, info.Size()-5); err != nil {
+		t.Fatal(err)
+	}
+	db, err = NewDb(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	check(db)
+}
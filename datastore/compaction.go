@@ -0,0 +1,259 @@
+package datastore
+
+import (
+	"bufio"
+	"os"
+	"slices"
+)
+
+// Compact merges all sealed segments into one and waits for the result.
+// Writes to the active segment are not blocked while it runs.
+func (db *Db) Compact() error {
+	if db.readOnly {
+		return ErrReadOnly
+	}
+	reply := make(chan error, 1)
+	select {
+	case db.compactReq <- reply:
+	case <-db.stop:
+		return ErrClosed
+	}
+	return <-reply
+}
+
+// compactionLoop is the single background worker merging the segments.
+// It runs compaction on request and whenever the policy says sealed segments need it.
+func (db *Db) compactionLoop() {
+	defer db.wg.Done()
+	for {
+		select {
+		case <-db.stop:
+			return
+		case reply := <-db.compactReq:
+			reply <- db.mergeSegments()
+		case <-db.compactTrigger:
+			if err := db.writeHints(); err != nil {
+				db.logger.Error("Cannot write hints", "err", err)
+			}
+			if !db.needsCompaction() {
+				continue
+			}
+			if err := db.mergeSegments(); err != nil {
+				db.logger.Error("Segment merge failed", "err", err)
+			}
+		}
+	}
+}
+
+// needsCompaction checks the sealed segments against the compaction policy.
+func (db *Db) needsCompaction() bool {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if len(db.segments) < 2 {
+		return false
+	}
+	sealed := db.segments[:len(db.segments)-1]
+	if db.compactSegments > 0 && len(sealed) >= db.compactSegments {
+		return true
+	}
+	var size, garbage int64
+	for _, seg := range sealed {
+		size += seg.size
+		garbage += seg.garbage
+	}
+	return db.compactGarbageRatio > 0 && size > 0 &&
+		float64(garbage)/float64(size) >= db.compactGarbageRatio
+}
+
+// recordMove describes a live record copied into the merged segment.
+type recordMove struct {
+	key    string
+	from   recordPosition
+	offset int64
+}
+
+// mergeSegments merges sealed data segments into a single one to save space.
+// Records are copied without holding the write lock, the index is switched
+// to the merged segment at the end for the keys that were not updated meanwhile.
+func (db *Db) mergeSegments() error {
+	db.mergeMu.Lock()
+	defer db.mergeMu.Unlock()
+
+	db.mu.RLock()
+	if db.segments == nil {
+		db.mu.RUnlock()
+		return ErrClosed
+	}
+	sealed := append([]*segment(nil), db.segments[:len(db.segments)-1]...)
+	db.mu.RUnlock()
+	if len(sealed) == 0 {
+		return nil
+	}
+	db.logger.Info("Merging segments", "count", len(sealed))
+
+	// The merged segment takes the place of the newest sealed one
+	id := sealed[len(sealed)-1].id
+	path := segmentPath(db.dir, id)
+	tempPath := path + compactingSuffix
+	tempFile, err := os.OpenFile(tempPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, db.fileMode)
+	if err != nil {
+		return err
+	}
+	out := bufio.NewWriterSize(tempFile, bufSize)
+
+	var (
+		moves   []recordMove
+		expired []recordMove
+		offset  int64
+		now     = db.clock().UnixNano()
+
+		movedVersion uint64 // Highest version of the copied records
+		dropped      entry  // Record with the highest version among the dropped ones
+	)
+	drop := func(e *entry) {
+		if e.version > dropped.version {
+			dropped = entry{key: e.key, version: e.version}
+		}
+	}
+	for _, seg := range sealed {
+		err = seg.scan(func(e *entry, from int64, data []byte) error {
+			if e.tombstone() {
+				// All the older segments are merged too, nothing is left for the tombstone to hide
+				drop(e)
+				return nil
+			}
+			db.mu.RLock()
+			position, ok := db.index[e.key]
+			db.mu.RUnlock()
+			if !ok || position.segment != seg || position.offset != from {
+				drop(e) // Stale record
+				return nil
+			}
+			if e.expired(now) {
+				drop(e)
+				expired = append(expired, recordMove{key: e.key, from: position})
+				return nil
+			}
+			if _, err := out.Write(data); err != nil {
+				return err
+			}
+			moves = append(moves, recordMove{key: e.key, from: position, offset: offset})
+			movedVersion = max(movedVersion, e.version)
+			offset += int64(len(data))
+			return nil
+		})
+		if err != nil {
+			break
+		}
+	}
+
+	// Versions must not repeat after a restart, so the highest one is kept
+	// with a tombstone when its record is dropped.
+	var marker *hint
+	if err == nil && dropped.version > movedVersion && !slices.ContainsFunc(moves, func(m recordMove) bool { return m.key == dropped.key }) {
+		dropped.flags = flagTombstone
+		data := dropped.Encode()
+		if _, err = out.Write(data); err == nil {
+			marker = &hint{key: dropped.key, offset: offset, size: int64(len(data)), deleted: true, version: dropped.version}
+			offset += int64(len(data))
+		}
+	}
+	if err == nil {
+		err = out.Flush()
+	}
+	if err == nil {
+		err = tempFile.Sync()
+	}
+	if closeErr := tempFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+
+	// From now on the merged data survives a crash, see finishCompaction
+	compactedPath := path + compactedSuffix
+	if err := os.Rename(tempPath, compactedPath); err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+	file, err := os.Open(compactedPath)
+	if err != nil {
+		return err
+	}
+	merged := newSegment(id, path, file)
+	merged.size = offset
+	if marker != nil {
+		merged.garbage += marker.size
+	}
+
+	db.mu.Lock()
+	for _, seg := range sealed {
+		if seg.id == id {
+			// Only the hint goes away, the data file is replaced by rename
+			err = os.Remove(hintPath(seg.path))
+		} else {
+			err = removeSegmentFiles(seg.path)
+		}
+		if err != nil && !os.IsNotExist(err) {
+			break
+		}
+		err = nil
+	}
+	if err == nil {
+		err = os.Rename(compactedPath, path)
+	}
+	if err != nil {
+		db.mu.Unlock()
+		merged.release()
+		return err
+	}
+	for _, move := range moves {
+		if db.index[move.key] == move.from {
+			db.index[move.key] = recordPosition{
+				segment:   merged,
+				offset:    move.offset,
+				size:      move.from.size,
+				expiresAt: move.from.expiresAt,
+				version:   move.from.version,
+			}
+		} else {
+			merged.garbage += move.from.size
+		}
+	}
+	for _, move := range expired {
+		if db.index[move.key] == move.from {
+			delete(db.index, move.key)
+		}
+	}
+	db.segments = append([]*segment{merged}, db.segments[len(sealed):]...)
+	db.mu.Unlock()
+
+	for _, seg := range sealed {
+		seg.release()
+	}
+
+	hints := make([]hint, len(moves))
+	for i, move := range moves {
+		hints[i] = hint{
+			key:       move.key,
+			offset:    move.offset,
+			size:      move.from.size,
+			expiresAt: move.from.expiresAt,
+			version:   move.from.version,
+		}
+	}
+	if marker != nil {
+		hints = append(hints, *marker)
+	}
+	if err := writeHint(merged, hints, db.fileMode); err != nil {
+		// Not fatal, the segment will be scanned on the next start
+		db.logger.Warn("Cannot write hint for merged segment", "segment", merged.path, "err", err)
+	} else {
+		merged.hinted = true
+	}
+	db.logger.Info("Segments merged", "segment", id, "size", merged.size)
+	return nil
+}
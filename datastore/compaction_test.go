@@ -0,0 +1,159 @@
+package datastore
+
+import (
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestDb_Compact(t *testing.T) {
+	dir := t.TempDir()
+	db, err := NewDb(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.segmentSize = 200
+	db.compactSegments = 0 // Only manual compaction
+	db.compactGarbageRatio = 0
+
+	for i := 0; i < 100; i++ {
+		if err := db.Put("key"+strconv.Itoa(i%10), "value"+strconv.Itoa(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Writers keep going while the segments are merged
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 100; i < 200; i++ {
+			if err := db.Put("key"+strconv.Itoa(i%10), "value"+strconv.Itoa(i)); err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+	if err := db.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+	if err := db.Compact(); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 190; i < 200; i++ {
+		value, err := db.Get("key" + strconv.Itoa(i%10))
+		if err != nil {
+			t.Errorf("Cannot get key%d: %s", i%10, err)
+		}
+		if value != "value"+strconv.Itoa(i) {
+			t.Errorf("Bad value returned expected value%d, got %s", i, value)
+		}
+	}
+
+	db.mu.RLock()
+	segments := len(db.segments)
+	merged := db.segments[0]
+	db.mu.RUnlock()
+	if segments != 2 {
+		t.Errorf("Expected merged and active segments, got %d", segments)
+	}
+	if info, err := os.Stat(merged.path); err != nil || info.Size() != merged.size {
+		t.Errorf("Unexpected merged segment state: %v", err)
+	}
+}
+
+func TestDb_CompactionPolicy(t *testing.T) {
+	dir := t.TempDir()
+	db, err := NewDb(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.segmentSize = 100
+	db.compactSegments = 3
+
+	for i := 0; i < 100; i++ {
+		if err := db.Put("key", "value"+strconv.Itoa(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Make sure the last policy check is finished
+	if err := db.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	ids, err := listSegments(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) > 3 {
+		t.Errorf("Expected segments to be merged, got %v", ids)
+	}
+	if value, _ := db.Get("key"); value != "value99" {
+		t.Errorf("Bad value returned expected value99, got %s", value)
+	}
+}
+
+func TestFinishCompaction(t *testing.T) {
+	dir := t.TempDir()
+	db, err := NewDb(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.segmentSize = 100
+	db.compactSegments = 0
+	db.compactGarbageRatio = 0
+	for i := 0; i < 20; i++ {
+		if err := db.Put("key"+strconv.Itoa(i), "value"+strconv.Itoa(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Pretend the process crashed after writing the merged segment
+	ids, err := listSegments(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) < 3 {
+		t.Fatalf("Expected several segments, got %v", ids)
+	}
+	last := ids[len(ids)-2]
+	var merged []byte
+	for _, id := range ids[:len(ids)-1] {
+		data, err := os.ReadFile(segmentPath(dir, id))
+		if err != nil {
+			t.Fatal(err)
+		}
+		merged = append(merged, data...)
+	}
+	if err := os.WriteFile(segmentPath(dir, last)+compactedSuffix, merged, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(segmentPath(dir, ids[0])); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = NewDb(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for i := 0; i < 20; i++ {
+		value, err := db.Get("key" + strconv.Itoa(i))
+		if err != nil || value != "value"+strconv.Itoa(i) {
+			t.Errorf("Bad value for key%d: %s, %v", i, value, err)
+		}
+	}
+	ids, err = listSegments(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 2 || ids[0] != last {
+		t.Errorf("Unexpected segments after recovery: %v", ids)
+	}
+}
@@ -0,0 +1,57 @@
+package datastore
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Values of at least the compression threshold are stored deflated
+// with flagCompressed set. Compaction and backups copy records as is,
+// values are inflated only when they are read.
+
+var flateWriters = sync.Pool{
+	New: func() any {
+		w, _ := flate.NewWriter(nil, flate.DefaultCompression)
+		return w
+	},
+}
+
+// compress deflates the value of the entry if it is large enough
+// and the compressed form is actually smaller.
+func (e *entry) compress(threshold int) {
+	if threshold <= 0 || len(e.value) < threshold || e.valueType == typeInt64 || e.flags&flagCompressed != 0 {
+		return
+	}
+
+	var buf bytes.Buffer
+	w := flateWriters.Get().(*flate.Writer)
+	defer flateWriters.Put(w)
+	w.Reset(&buf)
+	if _, err := io.WriteString(w, e.value); err != nil {
+		return
+	}
+	if err := w.Close(); err != nil || buf.Len() >= len(e.value) {
+		return
+	}
+	e.value = buf.String()
+	e.flags |= flagCompressed
+}
+
+// decompress restores the original value of a compressed entry.
+func (e *entry) decompress() error {
+	if e.flags&flagCompressed == 0 {
+		return nil
+	}
+	r := flate.NewReader(bytes.NewReader([]byte(e.value)))
+	defer r.Close()
+	value, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("cannot decompress value: %w", err)
+	}
+	e.value = string(value)
+	e.flags &^= flagCompressed
+	return nil
+}
@@ -0,0 +1,109 @@
+package datastore
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestDb_Compression(t *testing.T) {
+	dir := t.TempDir()
+	db, err := NewDb(dir, WithCompression(64))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	large := strings.Repeat(`{"name": "value", "count": 42}`, 100)
+	blob := bytes.Repeat([]byte{1, 2, 3, 4}, 100)
+	if err := db.Put("large", large); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put("small", "short value"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.PutBytes("blob", blob); err != nil {
+		t.Fatal(err)
+	}
+	var b Batch
+	b.Put("batch", large)
+	if err := db.Write(&b); err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(db *Db) {
+		t.Helper()
+		for _, key := range []string{"large", "batch"} {
+			if value, err := db.Get(key); err != nil || value != large {
+				t.Errorf("Bad value for %s: %d bytes, %v", key, len(value), err)
+			}
+		}
+		if value, err := db.Get("small"); err != nil || value != "short value" {
+			t.Errorf("Bad small value: %s, %v", value, err)
+		}
+		if value, err := db.GetBytes("blob"); err != nil || !bytes.Equal(value, blob) {
+			t.Errorf("Bad blob value: %v", err)
+		}
+		it, err := db.Prefix("large")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer it.Close()
+		if !it.Next() {
+			t.Fatal("Expected key in iterator")
+		}
+		if value, err := it.Value(); err != nil || value != large {
+			t.Errorf("Bad value from iterator: %v", err)
+		}
+	}
+	check(db)
+
+	info, err := os.Stat(segmentPath(dir, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() > int64(len(large)) {
+		t.Errorf("Expected compressed data, segment has %d bytes", info.Size())
+	}
+
+	// Reading does not depend on the option
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db, err = NewDb(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	check(db)
+	if err := db.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	check(db)
+}
+
+func TestEntryCompression(t *testing.T) {
+	e := entry{key: "key", value: strings.Repeat("a", 1000)}
+	e.compress(100)
+	if e.flags&flagCompressed == 0 || len(e.value) >= 1000 {
+		t.Fatalf("Expected compressed value, got %d bytes", len(e.value))
+	}
+
+	var decoded entry
+	if err := decoded.Decode(e.Encode()); err != nil {
+		t.Fatal(err)
+	}
+	if err := decoded.decompress(); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.value != strings.Repeat("a", 1000) || decoded.flags&flagCompressed != 0 {
+		t.Errorf("Bad decompressed value of %d bytes", len(decoded.value))
+	}
+
+	// Incompressible and small values are stored as is
+	small := entry{key: "key", value: "abc"}
+	small.compress(1)
+	if small.flags&flagCompressed != 0 || small.value != "abc" {
+		t.Errorf("Expected small value to stay uncompressed, got %q", small.value)
+	}
+}
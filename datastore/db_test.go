@@ -1,11 +1,14 @@
 package datastore
 
 import (
+	"errors"
 	"io/ioutil"
 	"os"
 	"strconv"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 	"log"
 )
 
@@ -128,3 +131,361 @@ func TestSegmentMerge(t *testing.T) {
 		}
 	}
 }
+
+// TestDb_Segments tests that the data is split into several segments
+func TestDb_Segments(t *testing.T) {
+	dir, err := ioutil.TempDir("", "test-db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := NewDb(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.segmentSize = 100
+
+	for i := 0; i < 20; i++ {
+		if err := db.Put("key"+strconv.Itoa(i%5), "value"+strconv.Itoa(i)); err != nil {
+			t.Fatalf("Cannot put key%d: %s", i%5, err)
+		}
+	}
+	db.mu.RLock()
+	segments := len(db.segments)
+	db.mu.RUnlock()
+	if segments < 2 {
+		t.Errorf("Expected several segments, got %d", segments)
+	}
+
+	check := func(db *Db) {
+		for i := 16; i < 20; i++ {
+			value, err := db.Get("key" + strconv.Itoa(i%5))
+			if err != nil {
+				t.Errorf("Cannot get key%d: %s", i%5, err)
+			}
+			if value != "value"+strconv.Itoa(i) {
+				t.Errorf("Bad value returned expected value%d, got %s", i, value)
+			}
+		}
+	}
+	check(db)
+
+	if err := db.Delete("key0"); err != nil {
+		t.Fatal(err)
+	}
+	db.mergeSegments()
+	check(db)
+
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db, err = NewDb(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	check(db)
+	if _, err := db.Get("key0"); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound for deleted key, got %v", err)
+	}
+}
+
+// TestDb_Corruption tests that damaged records are reported instead of returning garbage
+func TestDb_Corruption(t *testing.T) {
+	dir := t.TempDir()
+	db, err := NewDb(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := db.Put("key1", "value1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put("key2", "value2"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Damage the last byte of the first record
+	f, err := os.OpenFile(segmentPath(dir, 0), os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := int64(len((&entry{key: "key1", value: "value1"}).Encode()))
+	if _, err := f.WriteAt([]byte{'X'}, size-1); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	_, err = db.Get("key1")
+	if !errors.Is(err, ErrCorrupted) {
+		t.Fatalf("Expected ErrCorrupted, got %v", err)
+	}
+	var corruption *CorruptionError
+	if !errors.As(err, &corruption) || corruption.Offset != 0 || corruption.Segment != segmentPath(dir, 0) {
+		t.Errorf("Unexpected corruption details: %v", err)
+	}
+	if value, err := db.Get("key2"); err != nil || value != "value2" {
+		t.Errorf("Bad value for key2: %s, %v", value, err)
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewDb(dir); !errors.Is(err, ErrCorrupted) {
+		t.Errorf("Expected recovery to fail with ErrCorrupted, got %v", err)
+	}
+}
+
+// TestDb_TornWrite tests that recovery drops a partially written record at the end of the data
+func TestDb_TornWrite(t *testing.T) {
+	dir := t.TempDir()
+	db, err := NewDb(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put("key1", "value1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	validSize := int64(len((&entry{key: "key1", value: "value1", version: 1}).Encode()))
+
+	tails := map[string][]byte{
+		"short header": {0x20, 0x00},
+		"short record": (&entry{key: "key2", value: "value2"}).Encode()[:15],
+	}
+	for name, tail := range tails {
+		t.Run(name, func(t *testing.T) {
+			f, err := os.OpenFile(segmentPath(dir, 0), os.O_APPEND|os.O_WRONLY, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := f.Write(tail); err != nil {
+				t.Fatal(err)
+			}
+			f.Close()
+
+			db, err := NewDb(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			if info, err := os.Stat(segmentPath(dir, 0)); err != nil || info.Size() != validSize {
+				t.Errorf("Expected segment to be truncated to %d bytes: %v", validSize, err)
+			}
+			if value, err := db.Get("key1"); err != nil || value != "value1" {
+				t.Errorf("Bad value for key1: %s, %v", value, err)
+			}
+			if _, err := db.Get("key2"); err != ErrNotFound {
+				t.Errorf("Expected ErrNotFound for key2, got %v", err)
+			}
+		})
+	}
+}
+
+// TestDb_CorruptedTail tests that a complete last record failing the checksum is not dropped as a torn write
+func TestDb_CorruptedTail(t *testing.T) {
+	dir := t.TempDir()
+	db, err := NewDb(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put("key1", "value1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put("key2", "value2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(segmentPath(dir, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(segmentPath(dir, 0), os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt([]byte{'X'}, info.Size()-1); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	if _, err := NewDb(dir); !errors.Is(err, ErrCorrupted) || errors.Is(err, errIncompleteRecord) {
+		t.Errorf("Expected recovery to fail with ErrCorrupted, got %v", err)
+	}
+	if after, err := os.Stat(segmentPath(dir, 0)); err != nil || after.Size() != info.Size() {
+		t.Errorf("Expected segment to be kept intact: %v", err)
+	}
+}
+
+// TestDb_Closed tests that a closed database rejects reads and writes with ErrClosed
+func TestDb_Closed(t *testing.T) {
+	db, err := NewDb(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put("key1", "value1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.Get("key1"); err != ErrClosed {
+		t.Errorf("Expected ErrClosed from Get, got %v", err)
+	}
+	if err := db.Put("key1", "value2"); err != ErrClosed {
+		t.Errorf("Expected ErrClosed from Put, got %v", err)
+	}
+	if err := db.Delete("key1"); err != ErrClosed {
+		t.Errorf("Expected ErrClosed from Delete, got %v", err)
+	}
+	var b Batch
+	b.Put("key2", "value2")
+	if err := db.Write(&b); err != ErrClosed {
+		t.Errorf("Expected ErrClosed from Write, got %v", err)
+	}
+	if db.lastVersion != 1 {
+		t.Errorf("Expected rejected writes to take no versions, got %d", db.lastVersion)
+	}
+}
+
+// TestDb_Tombstones tests that deletions survive restarts and compaction without affecting other values
+func TestDb_Tombstones(t *testing.T) {
+	dir := t.TempDir()
+	db, err := NewDb(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.segmentSize = 100
+
+	// Used to be the deletion marker
+	if err := db.Put("marker", "DELETE"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		if err := db.Put("key"+strconv.Itoa(i), "value"+strconv.Itoa(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Delete("key1"); err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(db *Db) {
+		if value, err := db.Get("marker"); err != nil || value != "DELETE" {
+			t.Errorf("Bad value for marker: %s, %v", value, err)
+		}
+		if _, err := db.Get("key1"); err != ErrNotFound {
+			t.Errorf("Expected ErrNotFound for deleted key, got %v", err)
+		}
+	}
+	check(db)
+
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db, err = NewDb(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	check(db)
+
+	if err := db.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	check(db)
+}
+
+// TestDb_TTL tests that expired keys disappear on reads, recovery and compaction
+func TestDb_TTL(t *testing.T) {
+	dir := t.TempDir()
+	db, err := NewDb(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.segmentSize = 100
+	db.compactSegments = 0
+	db.compactGarbageRatio = 0
+
+	// Records written an hour ago with a minute to live
+	db.clock = func() time.Time { return time.Now().Add(-time.Hour) }
+	for i := 0; i < 10; i++ {
+		if err := db.PutWithTTL("session"+strconv.Itoa(i), "expired-value", time.Minute); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if value, err := db.Get("session0"); err != nil || value != "expired-value" {
+		t.Errorf("Bad value before expiration: %s, %v", value, err)
+	}
+	db.clock = time.Now
+	if err := db.PutWithTTL("fresh", "value", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put("permanent", "value"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.PutWithTTL("bad", "value", 0); err == nil {
+		t.Error("Expected error for zero TTL")
+	}
+
+	check := func(db *Db) {
+		for i := 0; i < 10; i++ {
+			if _, err := db.Get("session" + strconv.Itoa(i)); err != ErrNotFound {
+				t.Errorf("Expected ErrNotFound for expired key, got %v", err)
+			}
+		}
+		for _, key := range []string{"fresh", "permanent"} {
+			if value, err := db.Get(key); err != nil || value != "value" {
+				t.Errorf("Bad value for %s: %s, %v", key, value, err)
+			}
+		}
+		it, err := db.Prefix("session")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer it.Close()
+		if it.Next() {
+			t.Errorf("Expected no expired keys in iterator, got %s", it.Key())
+		}
+	}
+	check(db)
+
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db, err = NewDb(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	check(db)
+	db.mu.RLock()
+	keys := len(db.index)
+	db.mu.RUnlock()
+	if keys != 2 {
+		t.Errorf("Expected expired keys to be skipped on recovery, index has %d keys", keys)
+	}
+
+	if err := db.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	check(db)
+	db.mu.RLock()
+	path := db.segments[0].path
+	db.mu.RUnlock()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "expired-value") {
+		t.Error("Expected expired records to be dropped by compaction")
+	}
+}
@@ -3,37 +3,150 @@ package datastore
 import (
 	"encoding/binary"
 	"fmt"
+	"hash/crc32"
 )
 
+// Record layout: size | checksum | flags | type | [expiresAt] | [version] | keyLen | key | valLen | value.
+// The value is stored deflated with flagCompressed.
+// The checksum covers everything after itself, expiresAt is present with flagExpires
+// and version with flagVersion.
+const (
+	checksumEnd = 8
+	headerSize  = checksumEnd + 2
+)
+
+// Record flags
+const (
+	flagTombstone  byte = 1 << iota // The key is deleted, the record has no value
+	flagBatch                       // The value holds records written with Db.Write
+	flagExpires                     // The record has an expiration time
+	flagVersion                     // The record has a version number
+	flagCompressed                  // The value is deflated
+)
+
+// valueType tells how the stored value bytes are interpreted.
+type valueType byte
+
+const (
+	typeString valueType = iota
+	typeInt64
+	typeBytes
+)
+
+func (t valueType) String() string {
+	switch t {
+	case typeString:
+		return "string"
+	case typeInt64:
+		return "int64"
+	case typeBytes:
+		return "bytes"
+	}
+	return fmt.Sprintf("type(%d)", byte(t))
+}
+
 type entry struct {
 	key, value string
+	valueType  valueType
+	flags      byte
+	expiresAt  int64  // Unix time in nanoseconds, zero if the record does not expire
+	version    uint64 // Counts writes of the key, zero for records written before versions
+}
+
+func (e *entry) tombstone() bool {
+	return e.flags&flagTombstone != 0
+}
+
+func (e *entry) expired(now int64) bool {
+	return e.expiresAt != 0 && e.expiresAt <= now
+}
+
+// checkType makes sure the value of the entry has the expected type.
+func (e *entry) checkType(t valueType) error {
+	if e.valueType != t {
+		return fmt.Errorf("%w: %s holds %s, not %s", ErrTypeMismatch, e.key, e.valueType, t)
+	}
+	return nil
+}
+
+func (e *entry) int64() int64 {
+	return int64(binary.LittleEndian.Uint64([]byte(e.value)))
 }
 
 // Encode converts the entry to a byte slice
 func (e *entry) Encode() []byte {
-	fmt.Println("Encoding entry:", e.key, e.value)
-	kl := len(e.key) // Key length
+	kl := len(e.key)   // Key length
 	vl := len(e.value) // Value length
-	size := kl + vl + 12
+	flags, hl := e.flags&^(flagExpires|flagVersion), headerSize
+	if e.expiresAt != 0 {
+		flags |= flagExpires
+		hl += 8
+	}
+	if e.version != 0 {
+		flags |= flagVersion
+		hl += 8
+	}
+	size := kl + vl + hl + 8
 	res := make([]byte, size)
 	binary.LittleEndian.PutUint32(res, uint32(size))
-	binary.LittleEndian.PutUint32(res[4:], uint32(kl))
-	copy(res[8:], e.key)
-	binary.LittleEndian.PutUint32(res[kl+8:], uint32(vl))
-	copy(res[kl+12:], e.value)
+	res[checksumEnd] = flags
+	res[checksumEnd+1] = byte(e.valueType)
+	optional := res[headerSize:]
+	if e.expiresAt != 0 {
+		binary.LittleEndian.PutUint64(optional, uint64(e.expiresAt))
+		optional = optional[8:]
+	}
+	if e.version != 0 {
+		binary.LittleEndian.PutUint64(optional, e.version)
+	}
+	binary.LittleEndian.PutUint32(res[hl:], uint32(kl))
+	copy(res[hl+4:], e.key)
+	binary.LittleEndian.PutUint32(res[hl+kl+4:], uint32(vl))
+	copy(res[hl+kl+8:], e.value)
+	binary.LittleEndian.PutUint32(res[4:], crc32.ChecksumIEEE(res[checksumEnd:]))
 	return res
 }
 
-// Decode converts a byte slice back to an entry
-func (e *entry) Decode(input []byte) {
-	fmt.Println("Decoding entry")
-	kl := binary.LittleEndian.Uint32(input[4:])
-	keyBuf := make([]byte, kl)
-	copy(keyBuf, input[8:kl+8])
-	e.key = string(keyBuf)
+// Decode converts a byte slice back to an entry verifying its checksum
+func (e *entry) Decode(input []byte) error {
+	if len(input) < headerSize+8 || int(binary.LittleEndian.Uint32(input)) != len(input) {
+		return fmt.Errorf("bad record size")
+	}
+	if binary.LittleEndian.Uint32(input[4:]) != crc32.ChecksumIEEE(input[checksumEnd:]) {
+		return fmt.Errorf("checksum mismatch")
+	}
+	e.flags = input[checksumEnd]
+	e.valueType = valueType(input[checksumEnd+1])
+	if e.valueType > typeBytes {
+		return fmt.Errorf("unknown value type %d", e.valueType)
+	}
+	body := input[headerSize:]
+	e.expiresAt, e.version = 0, 0
+	if e.flags&flagExpires != 0 {
+		if len(body) < 16 {
+			return fmt.Errorf("bad record size")
+		}
+		e.expiresAt = int64(binary.LittleEndian.Uint64(body))
+		body = body[8:]
+	}
+	if e.flags&flagVersion != 0 {
+		if len(body) < 16 {
+			return fmt.Errorf("bad record size")
+		}
+		e.version = binary.LittleEndian.Uint64(body)
+		body = body[8:]
+	}
+
+	kl := binary.LittleEndian.Uint32(body)
+	if uint64(kl)+8 > uint64(len(body)) {
+		return fmt.Errorf("bad key length")
+	}
+	e.key = string(body[4 : kl+4])
 
-	vl := binary.LittleEndian.Uint32(input[kl+8:]) // Get value length
-	valBuf := make([]byte, vl)
-	copy(valBuf, input[kl+12:kl+12+vl])
-	e.value = string(valBuf)
+	vl := binary.LittleEndian.Uint32(body[kl+4:]) // Get value length
+	if uint64(kl)+8+uint64(vl) != uint64(len(body)) || (e.valueType == typeInt64 && vl != 8) {
+		return fmt.Errorf("bad value length")
+	}
+	e.value = string(body[kl+8:])
+	return nil
 }
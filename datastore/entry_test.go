@@ -19,3 +19,52 @@ func TestEntryEncoding(t *testing.T) {
 		t.Fatalf("expected value %s, got %s", e.value, decoded.value)
 	}
 }
+
+func TestEntryChecksum(t *testing.T) {
+	e := entry{
+		key:   "key1",
+		value: "value1",
+	}
+	encoded := e.Encode()
+	encoded[len(encoded)-1] ^= 0xff
+
+	var decoded entry
+	if err := decoded.Decode(encoded); err == nil {
+		t.Fatal("expected checksum error for damaged record")
+	}
+	if err := decoded.Decode(encoded[:10]); err == nil {
+		t.Fatal("expected error for truncated record")
+	}
+}
+
+func TestEntryTombstone(t *testing.T) {
+	e := entry{
+		key:   "key1",
+		flags: flagTombstone,
+	}
+	var decoded entry
+	if err := decoded.Decode(e.Encode()); err != nil {
+		t.Fatal(err)
+	}
+	if !decoded.tombstone() || decoded.key != e.key {
+		t.Fatalf("expected tombstone for %s, got %+v", e.key, decoded)
+	}
+}
+
+func TestEntryExpiry(t *testing.T) {
+	e := entry{
+		key:       "key1",
+		value:     "value1",
+		expiresAt: 1234567890,
+	}
+	var decoded entry
+	if err := decoded.Decode(e.Encode()); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.key != e.key || decoded.value != e.value || decoded.expiresAt != e.expiresAt {
+		t.Fatalf("expected %+v, got %+v", e, decoded)
+	}
+	if !decoded.expired(e.expiresAt) || decoded.expired(e.expiresAt-1) {
+		t.Errorf("bad expiration check for %+v", decoded)
+	}
+}
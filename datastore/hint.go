@@ -0,0 +1,182 @@
+package datastore
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+)
+
+// Hint files keep the index part of a sealed segment so that the segment
+// does not have to be read completely on startup.
+//
+// Layout: version | segmentSize | hint... | checksum, where every hint is
+// keyLen | key | offset | size | deleted | expiresAt | version.
+const (
+	hintSuffix     = ".hint"
+	hintTempSuffix = ".hint-temp"
+	hintVersion    = 2
+	hintFixedSize  = 33 // Size of a hint without the key
+)
+
+type hint struct {
+	key       string
+	offset    int64
+	size      int64
+	deleted   bool
+	expiresAt int64
+	version   uint64
+}
+
+func hintPath(segmentPath string) string {
+	return segmentPath + hintSuffix
+}
+
+// writeHint stores hints describing all the records of a sealed segment.
+func writeHint(seg *segment, hints []hint, mode os.FileMode) error {
+	path := hintPath(seg.path)
+	tempPath := seg.path + hintTempSuffix
+	f, err := os.OpenFile(tempPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode)
+	if err != nil {
+		return err
+	}
+	checksum := crc32.NewIEEE()
+	out := bufio.NewWriterSize(io.MultiWriter(f, checksum), bufSize)
+
+	var buf [hintFixedSize]byte
+	buf[0] = hintVersion
+	binary.LittleEndian.PutUint64(buf[1:], uint64(seg.size))
+	_, err = out.Write(buf[:9])
+	for _, h := range hints {
+		if err != nil {
+			break
+		}
+		binary.LittleEndian.PutUint32(buf[:], uint32(len(h.key)))
+		if _, err = out.Write(buf[:4]); err != nil {
+			break
+		}
+		if _, err = out.WriteString(h.key); err != nil {
+			break
+		}
+		binary.LittleEndian.PutUint64(buf[:], uint64(h.offset))
+		binary.LittleEndian.PutUint32(buf[8:], uint32(h.size))
+		buf[12] = 0
+		if h.deleted {
+			buf[12] = 1
+		}
+		binary.LittleEndian.PutUint64(buf[13:], uint64(h.expiresAt))
+		binary.LittleEndian.PutUint64(buf[21:], h.version)
+		_, err = out.Write(buf[:hintFixedSize-4])
+	}
+	if err == nil {
+		err = out.Flush()
+	}
+	if err == nil {
+		binary.LittleEndian.PutUint32(buf[:], checksum.Sum32())
+		_, err = f.Write(buf[:4])
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tempPath, path)
+	}
+	if err != nil {
+		os.Remove(tempPath)
+	}
+	return err
+}
+
+// readHint loads hints of the segment and checks they match its data file.
+// It also returns the size of the segment.
+func readHint(seg *segment) ([]hint, int64, error) {
+	data, err := os.ReadFile(hintPath(seg.path))
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(data) < 13 {
+		return nil, 0, fmt.Errorf("hint file is too short")
+	}
+	body := data[:len(data)-4]
+	if binary.LittleEndian.Uint32(data[len(body):]) != crc32.ChecksumIEEE(body) {
+		return nil, 0, fmt.Errorf("hint checksum mismatch")
+	}
+	if body[0] != hintVersion {
+		return nil, 0, fmt.Errorf("unsupported hint version %d", body[0])
+	}
+	body = body[1:]
+	info, err := seg.file.Stat()
+	if err != nil {
+		return nil, 0, err
+	}
+	size := int64(binary.LittleEndian.Uint64(body))
+	if size != info.Size() {
+		return nil, 0, fmt.Errorf("hint is for segment of %d bytes, got %d", size, info.Size())
+	}
+
+	var hints []hint
+	for body = body[8:]; len(body) > 0; {
+		if len(body) < 4 {
+			return nil, 0, fmt.Errorf("bad hint file")
+		}
+		kl := int(binary.LittleEndian.Uint32(body))
+		if len(body) < kl+hintFixedSize {
+			return nil, 0, fmt.Errorf("bad hint file")
+		}
+		hints = append(hints, hint{
+			key:       string(body[4 : kl+4]),
+			offset:    int64(binary.LittleEndian.Uint64(body[kl+4:])),
+			size:      int64(binary.LittleEndian.Uint32(body[kl+12:])),
+			deleted:   body[kl+16] == 1,
+			expiresAt: int64(binary.LittleEndian.Uint64(body[kl+17:])),
+			version:   binary.LittleEndian.Uint64(body[kl+25:]),
+		})
+		body = body[kl+hintFixedSize:]
+	}
+	return hints, size, nil
+}
+
+// writeHints creates missing hint files for the sealed segments.
+func (db *Db) writeHints() error {
+	db.mergeMu.Lock()
+	defer db.mergeMu.Unlock()
+
+	db.mu.RLock()
+	if db.segments == nil {
+		db.mu.RUnlock()
+		return ErrClosed
+	}
+	sealed := append([]*segment(nil), db.segments[:len(db.segments)-1]...)
+	db.mu.RUnlock()
+
+	for _, seg := range sealed {
+		if seg.hinted {
+			continue
+		}
+		var hints []hint
+		err := seg.scan(func(e *entry, offset int64, data []byte) error {
+			hints = append(hints, hint{
+				key:       e.key,
+				offset:    offset,
+				size:      int64(len(data)),
+				deleted:   e.tombstone(),
+				expiresAt: e.expiresAt,
+				version:   e.version,
+			})
+			return nil
+		})
+		if err == nil {
+			err = writeHint(seg, hints, db.fileMode)
+		}
+		if err != nil {
+			return fmt.Errorf("cannot write hint for %s: %w", seg.path, err)
+		}
+		seg.hinted = true
+	}
+	return nil
+}
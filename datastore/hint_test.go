@@ -0,0 +1,106 @@
+package datastore
+
+import (
+	"os"
+	"strconv"
+	"testing"
+)
+
+func TestDb_Hints(t *testing.T) {
+	dir := t.TempDir()
+	db, err := NewDb(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.segmentSize = 100
+	db.compactSegments = 0
+	db.compactGarbageRatio = 0
+	for i := 0; i < 20; i++ {
+		if err := db.Put("key"+strconv.Itoa(i), "value"+strconv.Itoa(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Delete("key0"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	ids, err := listSegments(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) < 3 {
+		t.Fatalf("Expected several segments, got %v", ids)
+	}
+	for _, id := range ids[:len(ids)-1] {
+		if _, err := os.Stat(hintPath(segmentPath(dir, id))); err != nil {
+			t.Errorf("Expected hint for segment %d: %s", id, err)
+		}
+	}
+
+	// Damage the deleted record: with the hint recovery does not need to read it
+	f, err := os.OpenFile(segmentPath(dir, ids[0]), os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt([]byte{'X'}, 10); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	db, err = NewDb(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for i := 1; i < 20; i++ {
+		key := "key" + strconv.Itoa(i)
+		if value, err := db.Get(key); err != nil || value != "value"+strconv.Itoa(i) {
+			t.Errorf("Bad value for %s: %s, %v", key, value, err)
+		}
+	}
+	if _, err := db.Get("key0"); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound for deleted key, got %v", err)
+	}
+}
+
+func TestDb_BadHintIsIgnored(t *testing.T) {
+	dir := t.TempDir()
+	db, err := NewDb(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.segmentSize = 100
+	for i := 0; i < 10; i++ {
+		if err := db.Put("key"+strconv.Itoa(i), "value"+strconv.Itoa(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	path := hintPath(segmentPath(dir, 0))
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)-5] ^= 0xff
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = NewDb(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for i := 0; i < 10; i++ {
+		key := "key" + strconv.Itoa(i)
+		if value, err := db.Get(key); err != nil || value != "value"+strconv.Itoa(i) {
+			t.Errorf("Bad value for %s: %s, %v", key, value, err)
+		}
+	}
+}
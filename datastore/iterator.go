@@ -0,0 +1,144 @@
+package datastore
+
+import (
+	"sort"
+	"strings"
+)
+
+type iteratorItem struct {
+	key      string
+	position recordPosition
+}
+
+// Iterator walks over keys in ascending order. It sees the database as it was
+// when the iterator was created: later writes and compaction do not affect it.
+// Values are read on demand. The iterator must be closed after use.
+type Iterator struct {
+	items    []iteratorItem
+	current  int
+	segments []*segment // referenced to be readable after compaction
+	entry    *entry     // value of the current key once read
+	err      error
+}
+
+// Scan returns an iterator over keys in the range [start, end).
+// Empty end means there is no upper bound.
+func (db *Db) Scan(start, end string) (*Iterator, error) {
+	return db.iterator(inRange(start, end))
+}
+
+// Prefix returns an iterator over keys starting with the prefix.
+func (db *Db) Prefix(prefix string) (*Iterator, error) {
+	return db.iterator(withPrefix(prefix))
+}
+
+func inRange(start, end string) func(key string) bool {
+	return func(key string) bool {
+		return key >= start && (end == "" || key < end)
+	}
+}
+
+func withPrefix(prefix string) func(key string) bool {
+	return func(key string) bool {
+		return strings.HasPrefix(key, prefix)
+	}
+}
+
+func (db *Db) iterator(match func(key string) bool) (*Iterator, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.segments == nil {
+		return nil, ErrClosed
+	}
+	return newIterator(db.index, db.segments, db.clock().UnixNano(), match), nil
+}
+
+// newIterator collects matching keys of the index not expired by now,
+// the caller makes sure the index and segments do not change meanwhile.
+func newIterator(index hashIndex, segments []*segment, now int64, match func(key string) bool) *Iterator {
+	it := &Iterator{current: -1}
+	for key, position := range index {
+		if match(key) && !position.expired(now) {
+			it.items = append(it.items, iteratorItem{key: key, position: position})
+		}
+	}
+	it.segments = append(it.segments, segments...)
+	for _, seg := range it.segments {
+		seg.acquire()
+	}
+	sort.Slice(it.items, func(i, j int) bool {
+		return it.items[i].key < it.items[j].key
+	})
+	return it
+}
+
+// Next moves to the next key returning false when there are no more keys.
+func (it *Iterator) Next() bool {
+	if it.current >= len(it.items) {
+		return false
+	}
+	it.current++
+	it.entry, it.err = nil, nil
+	return it.current < len(it.items)
+}
+
+// Key returns the current key.
+func (it *Iterator) Key() string {
+	return it.items[it.current].key
+}
+
+// Value returns the current string value.
+func (it *Iterator) Value() (string, error) {
+	e, err := it.read(typeString)
+	if err != nil {
+		return "", err
+	}
+	return e.value, nil
+}
+
+// Int64 returns the current integer value.
+func (it *Iterator) Int64() (int64, error) {
+	e, err := it.read(typeInt64)
+	if err != nil {
+		return 0, err
+	}
+	return e.int64(), nil
+}
+
+// Bytes returns the current binary value.
+func (it *Iterator) Bytes() ([]byte, error) {
+	e, err := it.read(typeBytes)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(e.value), nil
+}
+
+func (it *Iterator) read(t valueType) (*entry, error) {
+	if it.segments == nil {
+		return nil, ErrClosed
+	}
+	if it.entry == nil && it.err == nil {
+		item := it.items[it.current]
+		it.entry, it.err = item.position.segment.readEntry(item.position.offset)
+	}
+	if it.err != nil {
+		return nil, it.err
+	}
+	if err := it.entry.checkType(t); err != nil {
+		return nil, err
+	}
+	return it.entry, nil
+}
+
+// Close releases the data files held by the iterator.
+func (it *Iterator) Close() error {
+	var firstErr error
+	for _, seg := range it.segments {
+		if err := seg.release(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	it.segments = nil
+	return firstErr
+}
@@ -0,0 +1,119 @@
+package datastore
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func collect(t *testing.T, it *Iterator) []string {
+	t.Helper()
+	defer it.Close()
+	var res []string
+	for it.Next() {
+		value, err := it.Value()
+		if err != nil {
+			t.Fatalf("Cannot read %s: %s", it.Key(), err)
+		}
+		res = append(res, it.Key()+"="+value)
+	}
+	return res
+}
+
+func TestDb_Scan(t *testing.T) {
+	db, err := NewDb(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for _, key := range []string{"tenant2/b", "tenant1/b", "tenant1/a", "tenant10/a", "other"} {
+		if err := db.Put(key, "v-"+key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Delete("tenant1/b"); err != nil {
+		t.Fatal(err)
+	}
+
+	it, err := db.Prefix("tenant1/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res := collect(t, it); !reflect.DeepEqual(res, []string{"tenant1/a=v-tenant1/a"}) {
+		t.Errorf("Unexpected prefix result %v", res)
+	}
+
+	it, err = db.Scan("tenant1", "tenant2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"tenant1/a=v-tenant1/a", "tenant10/a=v-tenant10/a"}
+	if res := collect(t, it); !reflect.DeepEqual(res, expected) {
+		t.Errorf("Unexpected scan result %v", res)
+	}
+
+	it, err = db.Scan("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res := collect(t, it); len(res) != 4 || res[0] != "other=v-other" {
+		t.Errorf("Unexpected full scan result %v", res)
+	}
+}
+
+func TestIterator_Snapshot(t *testing.T) {
+	db, err := NewDb(t.TempDir(), WithSegmentSize(100), WithCompaction(0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for i := 0; i < 10; i++ {
+		if err := db.Put("key"+strconv.Itoa(i), "old"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.PutInt64("number", 7); err != nil {
+		t.Fatal(err)
+	}
+
+	it, err := db.Prefix("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer it.Close()
+
+	// Neither new writes nor compaction are visible to the iterator
+	for i := 0; i < 10; i++ {
+		if err := db.Put("key"+strconv.Itoa(i), "new"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Put("key99", "new"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Compact(); err != nil {
+		t.Fatal(err)
+	}
+
+	count := 0
+	for it.Next() {
+		count++
+		if it.Key() == "number" {
+			if value, err := it.Int64(); err != nil || value != 7 {
+				t.Errorf("Bad value for number: %d, %v", value, err)
+			}
+			if _, err := it.Value(); err == nil {
+				t.Error("Expected type mismatch reading int64 as string")
+			}
+			continue
+		}
+		if value, err := it.Value(); err != nil || value != "old" {
+			t.Errorf("Bad value for %s: %s, %v", it.Key(), value, err)
+		}
+	}
+	if count != 11 {
+		t.Errorf("Expected 11 keys, got %d", count)
+	}
+}
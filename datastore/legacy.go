@@ -0,0 +1,90 @@
+package datastore
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+)
+
+const (
+	// Legacy record layout: size | keyLen | key | valLen | value, without checksums.
+	legacyHeaderSize = 12
+	// The legacy storage deleted keys by writing this value.
+	legacyDeleteMarker = "DELETE"
+)
+
+// decodeLegacy converts a record of the legacy single-file storage to an entry.
+// Deletion markers become tombstones.
+func decodeLegacy(input []byte) (*entry, error) {
+	if len(input) < legacyHeaderSize || int(binary.LittleEndian.Uint32(input)) != len(input) {
+		return nil, fmt.Errorf("bad record size")
+	}
+	kl := binary.LittleEndian.Uint32(input[4:])
+	if uint64(kl)+legacyHeaderSize > uint64(len(input)) {
+		return nil, fmt.Errorf("bad key length")
+	}
+	vl := binary.LittleEndian.Uint32(input[kl+8:])
+	if uint64(kl)+uint64(vl)+legacyHeaderSize != uint64(len(input)) {
+		return nil, fmt.Errorf("bad value length")
+	}
+	key, value := string(input[8:kl+8]), string(input[kl+12:])
+	if value == legacyDeleteMarker {
+		return &entry{key: key, flags: flagTombstone}, nil
+	}
+	return &entry{key: key, value: value}, nil
+}
+
+// convertLegacy re-encodes all records of the legacy file in the current format.
+// An incomplete last record left by a crash is dropped.
+func convertLegacy(path string, w io.Writer, logger *slog.Logger) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	in := bufio.NewReaderSize(f, bufSize)
+	var offset int64
+	for {
+		header, err := in.Peek(4)
+		if err == io.EOF && len(header) == 0 {
+			return nil
+		} else if err != nil && err != io.EOF {
+			return err
+		}
+		if len(header) < 4 {
+			return discardLegacyTail(path, offset, logger)
+		}
+		size := int(binary.LittleEndian.Uint32(header))
+		if size < legacyHeaderSize {
+			return fmt.Errorf("legacy record in %s at offset %d: bad record size", path, offset)
+		}
+		data := make([]byte, size)
+		if _, err := io.ReadFull(in, data); err == io.ErrUnexpectedEOF {
+			return discardLegacyTail(path, offset, logger)
+		} else if err != nil {
+			return err
+		}
+		e, err := decodeLegacy(data)
+		if err != nil {
+			return fmt.Errorf("legacy record in %s at offset %d: %w", path, offset, err)
+		}
+		if _, err := w.Write(e.Encode()); err != nil {
+			return err
+		}
+		offset += int64(size)
+	}
+}
+
+// discardLegacyTail logs the incomplete record at the end of the legacy file that is left out of the migration.
+func discardLegacyTail(path string, offset int64, logger *slog.Logger) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	logger.Warn("Discarding incomplete record", "file", path, "offset", offset, "bytes", info.Size()-offset)
+	return nil
+}
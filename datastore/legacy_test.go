@@ -0,0 +1,105 @@
+package datastore
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func encodeLegacy(key, value string) []byte {
+	size := legacyHeaderSize + len(key) + len(value)
+	res := make([]byte, size)
+	binary.LittleEndian.PutUint32(res, uint32(size))
+	binary.LittleEndian.PutUint32(res[4:], uint32(len(key)))
+	copy(res[8:], key)
+	binary.LittleEndian.PutUint32(res[8+len(key):], uint32(len(value)))
+	copy(res[12+len(key):], value)
+	return res
+}
+
+func TestDb_MigrateLegacy(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		pairs    [][2]string
+		expected map[string]string
+		deleted  []string
+	}{
+		{"one record", [][2]string{{"key1", "value1"}}, map[string]string{"key1": "value1"}, nil},
+		{"three records", [][2]string{{"key1", "value1"}, {"key2", "value2"}, {"key1", "value3"}}, map[string]string{"key1": "value3", "key2": "value2"}, nil},
+		{"deletion", [][2]string{{"key1", "value1"}, {"key2", "value2"}, {"key1", legacyDeleteMarker}}, map[string]string{"key2": "value2"}, []string{"key1"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			var data []byte
+			for _, pair := range tc.pairs {
+				data = append(data, encodeLegacy(pair[0], pair[1])...)
+			}
+			legacyPath := filepath.Join(dir, outFileName)
+			if err := os.WriteFile(legacyPath, data, 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			db, err := NewDb(dir)
+			if err != nil {
+				t.Fatalf("Cannot open legacy file: %s", err)
+			}
+			defer db.Close()
+			for key, value := range tc.expected {
+				if got, err := db.Get(key); err != nil || got != value {
+					t.Errorf("Bad value for %s after migration: %s, %v", key, got, err)
+				}
+			}
+			for _, key := range tc.deleted {
+				if got, err := db.Get(key); err != ErrNotFound {
+					t.Errorf("Expected %s to stay deleted, got %q, %v", key, got, err)
+				}
+			}
+			if _, err := os.Stat(legacyPath); !os.IsNotExist(err) {
+				t.Errorf("Expected legacy file to be removed, got %v", err)
+			}
+		})
+	}
+}
+
+func TestDb_MigrateLegacyTornTail(t *testing.T) {
+	for _, tail := range []int{2, 10} {
+		dir := t.TempDir()
+		legacyPath := filepath.Join(dir, outFileName)
+		data := append(encodeLegacy("key1", "value1"), encodeLegacy("key2", "value2")[:tail]...)
+		if err := os.WriteFile(legacyPath, data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		db, err := NewDb(dir)
+		if err != nil {
+			t.Fatalf("Cannot open legacy file with %d bytes of a torn record: %s", tail, err)
+		}
+		if value, err := db.Get("key1"); err != nil || value != "value1" {
+			t.Errorf("Expected key1 to survive migration, got %q, %v", value, err)
+		}
+		if _, err := db.Get("key2"); err != ErrNotFound {
+			t.Errorf("Expected torn key2 to be dropped, got %v", err)
+		}
+		db.Close()
+	}
+}
+
+func TestDb_MigrateLegacyBroken(t *testing.T) {
+	dir := t.TempDir()
+	legacyPath := filepath.Join(dir, outFileName)
+	broken := encodeLegacy("key2", "value2")
+	binary.LittleEndian.PutUint32(broken[4:], 100) // Key length past the end of the record
+	data := append(encodeLegacy("key1", "value1"), broken...)
+	if err := os.WriteFile(legacyPath, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewDb(dir); err == nil {
+		t.Fatal("Expected error for broken legacy file")
+	}
+	if kept, err := os.ReadFile(legacyPath); err != nil || len(kept) != len(data) {
+		t.Errorf("Expected legacy file to be kept intact: %v", err)
+	}
+	if ids, err := listSegments(dir); err != nil || len(ids) != 0 {
+		t.Errorf("Expected no segments, got %v, %v", ids, err)
+	}
+}
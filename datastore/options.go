@@ -0,0 +1,98 @@
+package datastore
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// Option configures the database created with NewDb.
+type Option func(db *Db)
+
+// WithSegmentSize sets the size after which the active segment is sealed.
+func WithSegmentSize(size int64) Option {
+	return func(db *Db) {
+		db.segmentSize = size
+	}
+}
+
+// WithSyncPolicy sets when written records are flushed to the disk.
+// By default records are never synced explicitly.
+func WithSyncPolicy(policy SyncPolicy) Option {
+	return func(db *Db) {
+		db.syncPolicy = policy
+	}
+}
+
+// WithSyncInterval sets how often records are synced with the SyncInterval policy.
+func WithSyncInterval(interval time.Duration) Option {
+	return func(db *Db) {
+		db.syncInterval = interval
+	}
+}
+
+// WithCompaction sets when sealed segments are merged in background:
+// once there are maxSegments of them or stale records take garbageRatio
+// of their space. Zero disables the corresponding trigger.
+func WithCompaction(maxSegments int, garbageRatio float64) Option {
+	return func(db *Db) {
+		db.compactSegments = maxSegments
+		db.compactGarbageRatio = garbageRatio
+	}
+}
+
+// WithCompression enables compression of values of at least threshold bytes.
+// Zero disables compression, which is the default.
+func WithCompression(threshold int) Option {
+	return func(db *Db) {
+		db.compressThreshold = threshold
+	}
+}
+
+// WithLogger sets the logger for database events.
+func WithLogger(logger *slog.Logger) Option {
+	return func(db *Db) {
+		db.logger = logger
+	}
+}
+
+// WithFileMode sets permissions of the created data files.
+func WithFileMode(mode os.FileMode) Option {
+	return func(db *Db) {
+		db.fileMode = mode
+	}
+}
+
+// ReadOnly opens the database without modifying its files.
+// Writes and compaction fail with ErrReadOnly.
+func ReadOnly() Option {
+	return func(db *Db) {
+		db.readOnly = true
+	}
+}
+
+// validate checks the configuration after all the options are applied.
+func (db *Db) validate() error {
+	switch {
+	case db.segmentSize <= 0:
+		return fmt.Errorf("segment size must be positive, got %d", db.segmentSize)
+	case db.syncPolicy < SyncNever || db.syncPolicy > SyncInterval:
+		return fmt.Errorf("unknown sync policy %s", db.syncPolicy)
+	case db.syncPolicy == SyncInterval && db.syncInterval <= 0:
+		return fmt.Errorf("sync interval must be positive, got %s", db.syncInterval)
+	case db.compactSegments < 0:
+		return fmt.Errorf("compaction segment count must not be negative, got %d", db.compactSegments)
+	case db.compactGarbageRatio < 0 || db.compactGarbageRatio > 1:
+		return fmt.Errorf("compaction garbage ratio must be between 0 and 1, got %g", db.compactGarbageRatio)
+	case db.compressThreshold < 0:
+		return fmt.Errorf("compression threshold must not be negative, got %d", db.compressThreshold)
+	case db.logger == nil:
+		return fmt.Errorf("logger must not be nil")
+	case db.fileMode&^os.ModePerm != 0 || db.fileMode&0o600 != 0o600:
+		return fmt.Errorf("file mode %s must be readable and writable by the owner", db.fileMode)
+	case db.readOnly && db.syncPolicy != SyncNever:
+		return fmt.Errorf("sync policy %s cannot be used in read-only mode", db.syncPolicy)
+	}
+	return nil
+}
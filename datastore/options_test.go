@@ -0,0 +1,103 @@
+package datastore
+
+import (
+	"bytes"
+	"log/slog"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewDb_Options(t *testing.T) {
+	dir := t.TempDir()
+	var logs bytes.Buffer
+	db, err := NewDb(dir,
+		WithSegmentSize(100),
+		WithCompaction(0, 0),
+		WithFileMode(0o640),
+		WithLogger(slog.New(slog.NewTextHandler(&logs, nil))),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		if err := db.Put("key", "value"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	ids, err := listSegments(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) < 2 {
+		t.Errorf("Expected segments of 100 bytes, got %v", ids)
+	}
+	info, err := os.Stat(segmentPath(dir, ids[len(ids)-1]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o640 {
+		t.Errorf("Expected file mode 0640, got %s", info.Mode().Perm())
+	}
+	if !strings.Contains(logs.String(), "Database recovered") {
+		t.Errorf("Expected logs to be written to the configured logger, got %s", logs.String())
+	}
+}
+
+func TestNewDb_InvalidOptions(t *testing.T) {
+	invalid := map[string][]Option{
+		"segment size":       {WithSegmentSize(0)},
+		"sync interval":      {WithSyncPolicy(SyncInterval), WithSyncInterval(-time.Second)},
+		"sync policy":        {WithSyncPolicy(SyncPolicy(42))},
+		"compaction count":   {WithCompaction(-1, 0.5)},
+		"compaction ratio":   {WithCompaction(4, 1.5)},
+		"compression":        {WithCompression(-1)},
+		"logger":             {WithLogger(nil)},
+		"file mode":          {WithFileMode(0o400)},
+		"read-only and sync": {ReadOnly(), WithSyncPolicy(SyncAlways)},
+	}
+	for name, opts := range invalid {
+		t.Run(name, func(t *testing.T) {
+			if db, err := NewDb(t.TempDir(), opts...); err == nil {
+				db.Close()
+				t.Error("Expected validation error")
+			}
+		})
+	}
+}
+
+func TestNewDb_ReadOnly(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := NewDb(dir, ReadOnly()); err == nil {
+		t.Error("Expected error opening empty directory in read-only mode")
+	}
+
+	db, err := NewDb(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := db.Put("key", "value"); err != nil {
+		t.Fatal(err)
+	}
+
+	reader, err := NewDb(dir, ReadOnly())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	if value, err := reader.Get("key"); err != nil || value != "value" {
+		t.Errorf("Bad value for key: %s, %v", value, err)
+	}
+	if err := reader.Put("key", "other"); err != ErrReadOnly {
+		t.Errorf("Expected ErrReadOnly on put, got %v", err)
+	}
+	if err := reader.Compact(); err != ErrReadOnly {
+		t.Errorf("Expected ErrReadOnly on compaction, got %v", err)
+	}
+}
@@ -0,0 +1,246 @@
+package datastore
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// errIncompleteRecord marks a record cut off by the end of the file.
+var errIncompleteRecord = fmt.Errorf("incomplete record")
+
+const (
+	segmentPrefix    = "segment-"
+	compactedSuffix  = ".compact"
+	compactingSuffix = ".compact-temp"
+)
+
+// segment is a single append-only data file of the database.
+// Only the last segment is written to, all the others are sealed.
+type segment struct {
+	id   int
+	path string
+	file *os.File // read handle shared by all readers
+
+	size    int64 // known for sealed segments only
+	garbage int64 // bytes taken by overwritten or deleted records
+	hinted  bool  // whether the hint file is written
+
+	// The database holds a reference while the segment is in use,
+	// iterators hold more to keep reading it after compaction.
+	refs atomic.Int32
+}
+
+func segmentPath(dir string, id int) string {
+	return filepath.Join(dir, segmentPrefix+strconv.Itoa(id))
+}
+
+// openSegment opens the segment file for reading.
+func openSegment(dir string, id int) (*segment, error) {
+	path := segmentPath(dir, id)
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return newSegment(id, path, f), nil
+}
+
+func newSegment(id int, path string, f *os.File) *segment {
+	seg := &segment{id: id, path: path, file: f}
+	seg.refs.Store(1)
+	return seg
+}
+
+// listSegments returns ids of all segment files in the directory in ascending order.
+func listSegments(dir string) ([]int, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var ids []int
+	for _, f := range files {
+		name := f.Name()
+		if f.IsDir() || !strings.HasPrefix(name, segmentPrefix) {
+			continue
+		}
+		id, err := strconv.Atoi(strings.TrimPrefix(name, segmentPrefix))
+		if err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids, nil
+}
+
+// finishCompaction completes a compaction interrupted after its result was fully written.
+// A compacted segment replaces all the segments with the same or lower id.
+func finishCompaction(dir string) error {
+	temps, err := filepath.Glob(filepath.Join(dir, segmentPrefix+"*"+compactingSuffix))
+	if err != nil {
+		return err
+	}
+	for _, path := range temps {
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+
+	compacted, err := filepath.Glob(filepath.Join(dir, segmentPrefix+"*"+compactedSuffix))
+	if err != nil {
+		return err
+	}
+	for _, path := range compacted {
+		name := strings.TrimSuffix(filepath.Base(path), compactedSuffix)
+		id, err := strconv.Atoi(strings.TrimPrefix(name, segmentPrefix))
+		if err != nil {
+			continue
+		}
+		ids, err := listSegments(dir)
+		if err != nil {
+			return err
+		}
+		for _, old := range ids {
+			if old <= id {
+				if err := removeSegmentFiles(segmentPath(dir, old)); err != nil {
+					return err
+				}
+			}
+		}
+		if err := os.Rename(path, segmentPath(dir, id)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeSegmentFiles removes the segment data file together with its hint.
+func removeSegmentFiles(path string) error {
+	for _, p := range []string{hintPath(path), path} {
+		if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// readRecord reads the encoded record stored at the given offset.
+// Only a record cut off by the end of the file is reported as corrupted,
+// other read errors are returned as is.
+func (s *segment) readRecord(offset int64) ([]byte, error) {
+	var header [4]byte
+	if _, err := s.file.ReadAt(header[:], offset); err != nil {
+		return nil, s.readFailed(offset, err)
+	}
+	size := binary.LittleEndian.Uint32(header[:])
+	if size < headerSize {
+		return nil, s.corrupted(offset, fmt.Errorf("bad record size"))
+	}
+	data := make([]byte, size)
+	if _, err := s.file.ReadAt(data, offset); err != nil {
+		return nil, s.readFailed(offset, err)
+	}
+	return data, nil
+}
+
+// readEntry reads and verifies the record stored at the given offset.
+func (s *segment) readEntry(offset int64) (*entry, error) {
+	data, err := s.readRecord(offset)
+	if err != nil {
+		return nil, err
+	}
+	var e entry
+	if err := e.Decode(data); err != nil {
+		return nil, s.corrupted(offset, err)
+	}
+	if err := e.decompress(); err != nil {
+		return nil, s.corrupted(offset, err)
+	}
+	return &e, nil
+}
+
+// scan reads all records of the segment in order and passes them to fn
+// together with their encoded form.
+func (s *segment) scan(fn func(e *entry, offset int64, data []byte) error) error {
+	input, err := os.Open(s.path)
+	if err != nil {
+		return err
+	}
+	defer input.Close()
+
+	in := bufio.NewReaderSize(input, bufSize)
+	var offset int64
+	for {
+		header, err := in.Peek(4)
+		if err == io.EOF && len(header) == 0 {
+			return nil
+		} else if err == io.EOF {
+			return s.corrupted(offset, errIncompleteRecord)
+		} else if err != nil {
+			return err
+		}
+		size := int(binary.LittleEndian.Uint32(header))
+		if size < headerSize {
+			return s.corrupted(offset, fmt.Errorf("bad record size"))
+		}
+
+		data := make([]byte, size)
+		if _, err := io.ReadFull(in, data); err == io.ErrUnexpectedEOF {
+			return s.corrupted(offset, errIncompleteRecord)
+		} else if err != nil {
+			return err
+		}
+
+		var e entry
+		if err := e.Decode(data); err != nil {
+			// A complete record that fails the check is damaged, not torn
+			return s.corrupted(offset, err)
+		}
+		if e.flags&flagBatch != 0 {
+			err = s.scanBatch(&e, offset, fn)
+		} else {
+			err = fn(&e, offset, data)
+		}
+		if err != nil {
+			return err
+		}
+		offset += int64(size)
+	}
+}
+
+// truncate cuts the segment file at the given offset dropping everything after it.
+func (s *segment) truncate(offset int64) error {
+	s.size = offset
+	return os.Truncate(s.path, offset)
+}
+
+func (s *segment) corrupted(offset int64, err error) error {
+	return &CorruptionError{Segment: s.path, Offset: offset, Err: err}
+}
+
+// readFailed reports a short read as an incomplete record and passes other errors through.
+func (s *segment) readFailed(offset int64, err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return s.corrupted(offset, errIncompleteRecord)
+	}
+	return err
+}
+
+func (s *segment) acquire() {
+	s.refs.Add(1)
+}
+
+// release drops a reference closing the file once it is not used anymore.
+func (s *segment) release() error {
+	if s.refs.Add(-1) == 0 {
+		return s.file.Close()
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package datastore
+
+// Snapshot is a read-only view of the database at the moment it was taken.
+// It keeps the data files it needs readable until closed, even when
+// they are merged by compaction meanwhile.
+type Snapshot struct {
+	index    hashIndex
+	segments []*segment
+	now      int64 // Records expired at this time are not visible
+}
+
+// Snapshot captures the current state of the database.
+// The snapshot must be closed after use.
+func (db *Db) Snapshot() (*Snapshot, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.segments == nil {
+		return nil, ErrClosed
+	}
+
+	s := &Snapshot{
+		index:    make(hashIndex, len(db.index)),
+		segments: append([]*segment(nil), db.segments...),
+		now:      db.clock().UnixNano(),
+	}
+	for key, position := range db.index {
+		if !position.expired(s.now) {
+			s.index[key] = position
+		}
+	}
+	for _, seg := range s.segments {
+		seg.acquire()
+	}
+	return s, nil
+}
+
+// Len returns the number of keys in the snapshot.
+func (s *Snapshot) Len() int {
+	return len(s.index)
+}
+
+func (s *Snapshot) get(key string, t valueType) (*entry, error) {
+	if s.segments == nil {
+		return nil, ErrClosed
+	}
+	position, ok := s.index[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	e, err := position.segment.readEntry(position.offset)
+	if err != nil {
+		return nil, err
+	}
+	if err := e.checkType(t); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// Get returns the string value of the key as of the snapshot.
+func (s *Snapshot) Get(key string) (string, error) {
+	e, err := s.get(key, typeString)
+	if err != nil {
+		return "", err
+	}
+	return e.value, nil
+}
+
+// GetInt64 returns the integer value of the key as of the snapshot.
+func (s *Snapshot) GetInt64(key string) (int64, error) {
+	e, err := s.get(key, typeInt64)
+	if err != nil {
+		return 0, err
+	}
+	return e.int64(), nil
+}
+
+// GetBytes returns the binary value of the key as of the snapshot.
+func (s *Snapshot) GetBytes(key string) ([]byte, error) {
+	e, err := s.get(key, typeBytes)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(e.value), nil
+}
+
+// Scan returns an iterator over snapshot keys in the range [start, end).
+func (s *Snapshot) Scan(start, end string) (*Iterator, error) {
+	if s.segments == nil {
+		return nil, ErrClosed
+	}
+	return newIterator(s.index, s.segments, s.now, inRange(start, end)), nil
+}
+
+// Prefix returns an iterator over snapshot keys starting with the prefix.
+func (s *Snapshot) Prefix(prefix string) (*Iterator, error) {
+	if s.segments == nil {
+		return nil, ErrClosed
+	}
+	return newIterator(s.index, s.segments, s.now, withPrefix(prefix)), nil
+}
+
+// Close releases the data files held by the snapshot.
+// Iterators created from the snapshot stay valid until closed.
+func (s *Snapshot) Close() error {
+	var firstErr error
+	for _, seg := range s.segments {
+		if err := seg.release(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	s.segments = nil
+	return firstErr
+}
@@ -0,0 +1,70 @@
+package datastore
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestDb_Snapshot(t *testing.T) {
+	db, err := NewDb(t.TempDir(), WithSegmentSize(100), WithCompaction(0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for i := 0; i < 10; i++ {
+		if err := db.Put("key"+strconv.Itoa(i), "old"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	snapshot, err := db.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 10; i++ {
+		if err := db.Put("key"+strconv.Itoa(i), "new"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Delete("key0"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put("added", "new"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Compact(); err != nil {
+		t.Fatal(err)
+	}
+
+	if snapshot.Len() != 10 {
+		t.Errorf("Expected 10 keys in snapshot, got %d", snapshot.Len())
+	}
+	for i := 0; i < 10; i++ {
+		key := "key" + strconv.Itoa(i)
+		if value, err := snapshot.Get(key); err != nil || value != "old" {
+			t.Errorf("Bad snapshot value for %s: %s, %v", key, value, err)
+		}
+	}
+	if _, err := snapshot.Get("added"); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound for key added after snapshot, got %v", err)
+	}
+
+	it, err := snapshot.Prefix("key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := snapshot.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if res := collect(t, it); len(res) != 10 || res[0] != "key0=old" {
+		t.Errorf("Unexpected snapshot iteration result %v", res)
+	}
+
+	if _, err := snapshot.Get("key1"); err != ErrClosed {
+		t.Errorf("Expected ErrClosed for closed snapshot, got %v", err)
+	}
+	if value, err := db.Get("key1"); err != nil || value != "new" {
+		t.Errorf("Bad value for key1: %s, %v", value, err)
+	}
+}
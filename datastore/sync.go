@@ -0,0 +1,112 @@
+package datastore
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// SyncPolicy defines when written records are flushed to the disk.
+type SyncPolicy int
+
+const (
+	// SyncNever leaves flushing to the operating system.
+	// Acknowledged writes may be lost on power failure.
+	SyncNever SyncPolicy = iota
+	// SyncAlways flushes every write before acknowledging it.
+	SyncAlways
+	// SyncInterval flushes periodically with a single fsync covering
+	// all the writes made since the previous one. Writers wait for it.
+	SyncInterval
+)
+
+const defaultSyncInterval = 10 * time.Millisecond
+
+func (p SyncPolicy) String() string {
+	switch p {
+	case SyncNever:
+		return "never"
+	case SyncAlways:
+		return "always"
+	case SyncInterval:
+		return "interval"
+	}
+	return fmt.Sprintf("SyncPolicy(%d)", int(p))
+}
+
+// syncLoop is the group commit worker of the SyncInterval policy.
+func (db *Db) syncLoop() {
+	defer db.wg.Done()
+	ticker := time.NewTicker(db.syncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-db.stop:
+			return
+		case <-ticker.C:
+			db.mu.RLock()
+			out, seq := db.out, db.writeSeq
+			db.mu.RUnlock()
+
+			db.syncMu.Lock()
+			synced := db.syncedSeq
+			db.syncMu.Unlock()
+			if seq <= synced {
+				continue
+			}
+
+			err := out.Sync()
+			if errors.Is(err, os.ErrClosed) {
+				// The segment was sealed meanwhile, sealing syncs it
+				continue
+			}
+			db.markSynced(seq, err)
+		}
+	}
+}
+
+// markSynced wakes up writers waiting for records up to seq to be flushed.
+func (db *Db) markSynced(seq uint64, err error) {
+	db.syncMu.Lock()
+	defer db.syncMu.Unlock()
+	if err != nil && db.syncErr == nil {
+		// Flushed state of earlier writes is unknown after a failed fsync
+		db.syncErr = fmt.Errorf("sync failed: %w", err)
+	}
+	if seq > db.syncedSeq {
+		db.syncedSeq = seq
+	}
+	db.syncCond.Broadcast()
+}
+
+// syncFailure returns the error of a failed fsync, the database refuses writes after it.
+func (db *Db) syncFailure() error {
+	db.syncMu.Lock()
+	defer db.syncMu.Unlock()
+	return db.syncErr
+}
+
+// waitDurable blocks until the write with the given sequence number is flushed.
+func (db *Db) waitDurable(seq uint64) error {
+	if db.syncPolicy != SyncInterval {
+		return nil
+	}
+	db.syncMu.Lock()
+	defer db.syncMu.Unlock()
+	for db.syncedSeq < seq && db.syncErr == nil {
+		db.syncCond.Wait()
+	}
+	return db.syncErr
+}
+
+// syncActive flushes the active segment according to the policy.
+// It is called with db.mu held.
+func (db *Db) syncActive() error {
+	if db.syncPolicy == SyncNever {
+		return nil
+	}
+	err := db.out.Sync()
+	db.markSynced(db.writeSeq, err)
+	return err
+}
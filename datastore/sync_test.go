@@ -0,0 +1,70 @@
+package datastore
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDb_SyncPolicies(t *testing.T) {
+	policies := []SyncPolicy{SyncNever, SyncAlways, SyncInterval}
+	for _, policy := range policies {
+		t.Run(policy.String(), func(t *testing.T) {
+			dir := t.TempDir()
+			db, err := NewDb(dir, WithSyncPolicy(policy), WithSyncInterval(5*time.Millisecond))
+			if err != nil {
+				t.Fatal(err)
+			}
+			db.segmentSize = 500
+
+			var wg sync.WaitGroup
+			for w := 0; w < 5; w++ {
+				wg.Add(1)
+				go func(w int) {
+					defer wg.Done()
+					for i := 0; i < 20; i++ {
+						key := "key" + strconv.Itoa(w) + "-" + strconv.Itoa(i)
+						if err := db.Put(key, "value"); err != nil {
+							t.Error(err)
+						}
+					}
+				}(w)
+			}
+			wg.Wait()
+
+			if policy != SyncNever {
+				db.mu.RLock()
+				written := db.writeSeq
+				db.mu.RUnlock()
+				db.syncMu.Lock()
+				synced := db.syncedSeq
+				db.syncMu.Unlock()
+				if synced != written {
+					t.Errorf("Expected all %d writes to be synced, got %d", written, synced)
+				}
+			}
+
+			if err := db.Close(); err != nil {
+				t.Fatal(err)
+			}
+			db, err = NewDb(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			for w := 0; w < 5; w++ {
+				key := "key" + strconv.Itoa(w) + "-19"
+				if value, err := db.Get(key); err != nil || value != "value" {
+					t.Errorf("Bad value for %s: %s, %v", key, value, err)
+				}
+			}
+		})
+	}
+}
+
+func TestNewDb_BadSyncInterval(t *testing.T) {
+	if _, err := NewDb(t.TempDir(), WithSyncPolicy(SyncInterval), WithSyncInterval(0)); err == nil {
+		t.Error("Expected error for zero sync interval")
+	}
+}
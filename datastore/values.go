@@ -0,0 +1,43 @@
+package datastore
+
+import (
+	"encoding/binary"
+)
+
+// PutInt64 stores an integer value under the key.
+func (db *Db) PutInt64(key string, value int64) error {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], uint64(value))
+	return db.put(&entry{
+		key:       key,
+		value:     string(buf[:]),
+		valueType: typeInt64,
+	})
+}
+
+// GetInt64 returns an integer value stored with PutInt64.
+func (db *Db) GetInt64(key string) (int64, error) {
+	e, err := db.get(key, typeInt64)
+	if err != nil {
+		return 0, err
+	}
+	return e.int64(), nil
+}
+
+// PutBytes stores a binary value under the key.
+func (db *Db) PutBytes(key string, value []byte) error {
+	return db.put(&entry{
+		key:       key,
+		value:     string(value),
+		valueType: typeBytes,
+	})
+}
+
+// GetBytes returns a binary value stored with PutBytes.
+func (db *Db) GetBytes(key string) ([]byte, error) {
+	e, err := db.get(key, typeBytes)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(e.value), nil
+}
@@ -0,0 +1,59 @@
+package datastore
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestDb_TypedValues(t *testing.T) {
+	dir := t.TempDir()
+	db, err := NewDb(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := db.PutInt64("counter", -42); err != nil {
+		t.Fatal(err)
+	}
+	blob := []byte{0, 1, 2, 0xff}
+	if err := db.PutBytes("blob", blob); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put("name", "value"); err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(db *Db) {
+		if value, err := db.GetInt64("counter"); err != nil || value != -42 {
+			t.Errorf("Bad counter value: %d, %v", value, err)
+		}
+		if value, err := db.GetBytes("blob"); err != nil || !bytes.Equal(value, blob) {
+			t.Errorf("Bad blob value: %v, %v", value, err)
+		}
+		if _, err := db.Get("counter"); !errors.Is(err, ErrTypeMismatch) {
+			t.Errorf("Expected ErrTypeMismatch reading int64 as string, got %v", err)
+		}
+		if _, err := db.GetInt64("name"); !errors.Is(err, ErrTypeMismatch) {
+			t.Errorf("Expected ErrTypeMismatch reading string as int64, got %v", err)
+		}
+		if _, err := db.GetBytes("counter"); !errors.Is(err, ErrTypeMismatch) {
+			t.Errorf("Expected ErrTypeMismatch reading int64 as bytes, got %v", err)
+		}
+		if _, err := db.GetInt64("missing"); err != ErrNotFound {
+			t.Errorf("Expected ErrNotFound, got %v", err)
+		}
+	}
+	check(db)
+
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db, err = NewDb(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	check(db)
+}
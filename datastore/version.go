@@ -0,0 +1,47 @@
+package datastore
+
+import "math"
+
+// Every write gets the next version of the database, so the version of a key
+// grows with every write and never repeats, even after the key is deleted and
+// written again. Missing keys have version 0.
+
+// anyVersion disables the version check of putIf.
+const anyVersion = math.MaxUint64
+
+// nextVersion returns the version of a new record, the caller holds the write lock.
+func (db *Db) nextVersion() uint64 {
+	db.lastVersion++
+	return db.lastVersion
+}
+
+// version returns the current version of the key, the caller holds the lock.
+func (db *Db) version(key string) uint64 {
+	position, ok := db.index[key]
+	if !ok || position.expired(db.clock().UnixNano()) {
+		return 0
+	}
+	return position.version
+}
+
+// GetWithVersion returns the string value of the key together with its version.
+func (db *Db) GetWithVersion(key string) (string, uint64, error) {
+	e, err := db.get(key, typeString)
+	if err != nil {
+		return "", 0, err
+	}
+	return e.value, max(e.version, 1), nil
+}
+
+// PutIf stores the value only if the key has the expected version,
+// otherwise it fails with ErrVersionMismatch. Version 0 expects the key
+// to be missing.
+func (db *Db) PutIf(key, value string, expectedVersion uint64) error {
+	return db.putIf(&entry{key: key, value: value}, expectedVersion)
+}
+
+// DeleteIf deletes the key only if it has the expected version,
+// otherwise it fails with ErrVersionMismatch.
+func (db *Db) DeleteIf(key string, expectedVersion uint64) error {
+	return db.putIf(&entry{key: key, flags: flagTombstone}, expectedVersion)
+}
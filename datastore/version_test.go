@@ -0,0 +1,242 @@
+package datastore
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestDb_Versions(t *testing.T) {
+	dir := t.TempDir()
+	db, err := NewDb(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.segmentSize = 100
+
+	version := func(db *Db, key string) uint64 {
+		t.Helper()
+		_, v, err := db.GetWithVersion(key)
+		if err != nil {
+			t.Fatalf("Cannot get %s: %s", key, err)
+		}
+		return v
+	}
+
+	if err := db.PutIf("key", "v1", 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.PutIf("key", "again", 0); !errors.Is(err, ErrVersionMismatch) {
+		t.Errorf("Expected ErrVersionMismatch creating existing key, got %v", err)
+	}
+	if err := db.Put("key", "v2"); err != nil {
+		t.Fatal(err)
+	}
+	if v := version(db, "key"); v != 2 {
+		t.Errorf("Expected version 2, got %d", v)
+	}
+	if err := db.PutIf("key", "stale", 1); !errors.Is(err, ErrVersionMismatch) {
+		t.Errorf("Expected ErrVersionMismatch, got %v", err)
+	}
+	if err := db.PutIf("key", "v3", 2); err != nil {
+		t.Fatal(err)
+	}
+	if value, v, err := db.GetWithVersion("key"); err != nil || value != "v3" || v != 3 {
+		t.Errorf("Bad value: %s, %d, %v", value, v, err)
+	}
+
+	if err := db.DeleteIf("key", 2); !errors.Is(err, ErrVersionMismatch) {
+		t.Errorf("Expected ErrVersionMismatch deleting, got %v", err)
+	}
+	if err := db.DeleteIf("missing", 0); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound deleting missing key, got %v", err)
+	}
+	if err := db.DeleteIf("key", 3); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Get("key"); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound after DeleteIf, got %v", err)
+	}
+
+	var b Batch
+	b.Put("batch", "1")
+	b.Put("batch", "2")
+	if err := db.Write(&b); err != nil {
+		t.Fatal(err)
+	}
+	batchVersion := version(db, "batch")
+	if batchVersion != 6 {
+		t.Errorf("Expected version 6 after batch, got %d", batchVersion)
+	}
+
+	for i := 0; i < 10; i++ {
+		if err := db.Put("counter", strconv.Itoa(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	counterVersion := version(db, "counter")
+
+	// Versions survive restarts, with and without hints, and compaction
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db, err = NewDb(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := version(db, "counter"); v != counterVersion {
+		t.Errorf("Expected version %d after restart, got %d", counterVersion, v)
+	}
+	if err := db.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if v := version(db, "counter"); v != counterVersion {
+		t.Errorf("Expected version %d after compaction, got %d", counterVersion, v)
+	}
+	if err := db.PutIf("counter", "10", counterVersion); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db, err = NewDb(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if v := version(db, "counter"); v != counterVersion+1 {
+		t.Errorf("Expected version %d, got %d", counterVersion+1, v)
+	}
+	if v := version(db, "batch"); v != batchVersion {
+		t.Errorf("Expected version %d for batch key, got %d", batchVersion, v)
+	}
+}
+
+// TestDb_VersionsAfterDelete tests that a recreated key does not reuse the versions seen before its deletion
+func TestDb_VersionsAfterDelete(t *testing.T) {
+	dir := t.TempDir()
+	db, err := NewDb(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.segmentSize = 1 // Every record gets its own segment
+
+	if err := db.Put("key", "old"); err != nil {
+		t.Fatal(err)
+	}
+	_, stale, err := db.GetWithVersion("key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Delete("key"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put("key", "new"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.PutIf("key", "lost update", stale); !errors.Is(err, ErrVersionMismatch) {
+		t.Errorf("Expected ErrVersionMismatch for stale version, got %v", err)
+	}
+
+	// The deleted key is dropped by compaction, versions still grow after a restart
+	if err := db.Delete("key"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		if err := db.Put("filler"+strconv.Itoa(i), "value"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Delete("filler4"); err != nil {
+		t.Fatal(err)
+	}
+	db.mu.RLock()
+	last := db.lastVersion
+	db.mu.RUnlock()
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db, err = NewDb(dir, WithCompaction(0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"with hints", "without hints"} {
+		if name == "without hints" {
+			ids, _ := listSegments(dir)
+			for _, id := range ids {
+				os.Remove(hintPath(segmentPath(dir, id)))
+			}
+		}
+		db, err = NewDb(dir, WithCompaction(0, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Put("key", "again"); err != nil {
+			t.Fatal(err)
+		}
+		if _, v, err := db.GetWithVersion("key"); err != nil || v <= last {
+			t.Errorf("Expected version above %d %s, got %d, %v", last, name, v, err)
+		}
+		db.mu.RLock()
+		last = db.lastVersion
+		db.mu.RUnlock()
+		if err := db.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// TestDb_PutIfConcurrent tests that only one of concurrent writers expecting the same version wins
+func TestDb_PutIfConcurrent(t *testing.T) {
+	db, err := NewDb(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := db.Put("key", "initial"); err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		wins int
+	)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			err := db.PutIf("key", strconv.Itoa(i), 1)
+			if err == nil {
+				mu.Lock()
+				wins++
+				mu.Unlock()
+			} else if !errors.Is(err, ErrVersionMismatch) {
+				t.Errorf("Unexpected error: %s", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if wins != 1 {
+		t.Errorf("Expected exactly one successful write, got %d", wins)
+	}
+}
+
+func TestEntryVersion(t *testing.T) {
+	e := entry{key: "key1", value: "value1", expiresAt: 42, version: 7}
+	var decoded entry
+	if err := decoded.Decode(e.Encode()); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.version != e.version || decoded.expiresAt != e.expiresAt || decoded.value != e.value {
+		t.Errorf("Expected %+v, got %+v", e, decoded)
+	}
+}
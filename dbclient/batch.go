@@ -0,0 +1,33 @@
+package dbclient
+
+type operation struct {
+	Key    string  `json:"key"`
+	Value  *string `json:"value,omitempty"`
+	Delete bool    `json:"delete,omitempty"`
+}
+
+// Batch collects writes that Client.Batch applies atomically.
+// The zero value is an empty batch ready to use.
+type Batch struct {
+	operations []operation
+}
+
+// Put adds writing the value to the batch.
+func (b *Batch) Put(key, value string) {
+	b.operations = append(b.operations, operation{Key: key, Value: &value})
+}
+
+// Delete adds deleting the key to the batch.
+func (b *Batch) Delete(key string) {
+	b.operations = append(b.operations, operation{Key: key, Delete: true})
+}
+
+// Len returns the number of operations in the batch.
+func (b *Batch) Len() int {
+	return len(b.operations)
+}
+
+// Reset clears the batch so it can be reused.
+func (b *Batch) Reset() {
+	b.operations = b.operations[:0]
+}
@@ -0,0 +1,272 @@
+// Package dbclient implements a client of the key-value service of cmd/db.
+package dbclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/roman-mazur/architecture-practice-4-template/datastore"
+)
+
+// Errors reported by the service are mapped back to the datastore ones.
+var (
+	ErrNotFound        = datastore.ErrNotFound
+	ErrTypeMismatch    = datastore.ErrTypeMismatch
+	ErrVersionMismatch = datastore.ErrVersionMismatch
+)
+
+const (
+	defaultTimeout   = 10 * time.Second
+	defaultRetries   = 3
+	defaultBackoff   = 100 * time.Millisecond
+	maxBackoff       = 5 * time.Second
+	maxMessageLength = 1024
+)
+
+// StatusError is returned when the service responds with an error status.
+// It matches ErrNotFound, ErrTypeMismatch and ErrVersionMismatch with errors.Is.
+type StatusError struct {
+	Method     string
+	Key        string
+	StatusCode int
+	Message    string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s %s: %d %s: %s", e.Method, e.Key, e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+func (e *StatusError) Unwrap() error {
+	switch e.StatusCode {
+	case http.StatusNotFound:
+		return ErrNotFound
+	case http.StatusConflict:
+		return ErrTypeMismatch
+	case http.StatusPreconditionFailed:
+		return ErrVersionMismatch
+	}
+	return nil
+}
+
+// Client sends requests to the key-value service.
+// It is safe for concurrent use.
+type Client struct {
+	baseURL string
+	http    *http.Client
+	retries int
+	backoff time.Duration
+}
+
+// Option configures the client created with New.
+type Option func(c *Client)
+
+// WithTimeout sets the time limit of a single request attempt.
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Client) {
+		c.http.Timeout = timeout
+	}
+}
+
+// WithRetries sets how many times requests failed with connection errors
+// or 5xx statuses are repeated. The delay before a retry starts with
+// backoff and doubles every attempt. Conditional writes are repeated only
+// if they could not be sent at all.
+func WithRetries(retries int, backoff time.Duration) Option {
+	return func(c *Client) {
+		c.retries = retries
+		c.backoff = backoff
+	}
+}
+
+// WithHTTPClient sets the HTTP client used for requests.
+func WithHTTPClient(client *http.Client) Option {
+	return func(c *Client) {
+		c.http = client
+	}
+}
+
+// New creates a client of the service available at baseURL, e.g. http://db:8091.
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		http:    &http.Client{Timeout: defaultTimeout},
+		retries: defaultRetries,
+		backoff: defaultBackoff,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+type record struct {
+	Key   string `json:"key,omitempty"`
+	Value string `json:"value"`
+}
+
+// Get returns the value stored for the key.
+func (c *Client) Get(ctx context.Context, key string) (string, error) {
+	value, _, err := c.get(ctx, key)
+	return value, err
+}
+
+// GetWithVersion returns the value stored for the key together with its version.
+func (c *Client) GetWithVersion(ctx context.Context, key string) (string, uint64, error) {
+	value, etag, err := c.get(ctx, key)
+	if err != nil {
+		return "", 0, err
+	}
+	version, err := strconv.ParseUint(strings.Trim(etag, `"`), 10, 64)
+	if err != nil {
+		return "", 0, fmt.Errorf("bad version %q for %s", etag, key)
+	}
+	return value, version, nil
+}
+
+// get returns the value of the key and its ETag.
+func (c *Client) get(ctx context.Context, key string) (string, string, error) {
+	resp, err := c.do(ctx, http.MethodGet, key, nil, nil)
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close()
+
+	var r record
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return "", "", fmt.Errorf("bad response for %s: %w", key, err)
+	}
+	return r.Value, resp.Header.Get("etag"), nil
+}
+
+// Put stores the value for the key.
+func (c *Client) Put(ctx context.Context, key, value string) error {
+	return c.put(ctx, key, value, nil)
+}
+
+// PutIf stores the value only if the key has the expected version,
+// otherwise it fails with ErrVersionMismatch. Version 0 expects the key
+// to be missing.
+func (c *Client) PutIf(ctx context.Context, key, value string, expectedVersion uint64) error {
+	return c.put(ctx, key, value, ifMatch(expectedVersion))
+}
+
+func (c *Client) put(ctx context.Context, key, value string, header http.Header) error {
+	body, err := json.Marshal(record{Value: value})
+	if err != nil {
+		return err
+	}
+	return c.send(ctx, http.MethodPost, key, body, header)
+}
+
+// Delete removes the key. Deleting a missing key is not an error.
+func (c *Client) Delete(ctx context.Context, key string) error {
+	return c.send(ctx, http.MethodDelete, key, nil, nil)
+}
+
+// DeleteIf removes the key only if it has the expected version,
+// otherwise it fails with ErrVersionMismatch.
+func (c *Client) DeleteIf(ctx context.Context, key string, expectedVersion uint64) error {
+	return c.send(ctx, http.MethodDelete, key, nil, ifMatch(expectedVersion))
+}
+
+func ifMatch(version uint64) http.Header {
+	return http.Header{"If-Match": {`"` + strconv.FormatUint(version, 10) + `"`}}
+}
+
+// Batch applies all the operations of b atomically.
+func (c *Client) Batch(ctx context.Context, b *Batch) error {
+	if len(b.operations) == 0 {
+		return nil
+	}
+	body, err := json.Marshal(b.operations)
+	if err != nil {
+		return err
+	}
+	return c.send(ctx, http.MethodPost, "", body, nil)
+}
+
+// send makes a request that has no response body.
+func (c *Client) send(ctx context.Context, method, key string, body []byte, header http.Header) error {
+	resp, err := c.do(ctx, method, key, body, header)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// do sends the request retrying temporary failures and converting error
+// statuses to errors. An empty key addresses the batch endpoint.
+func (c *Client) do(ctx context.Context, method, key string, body []byte, header http.Header) (*http.Response, error) {
+	target := c.baseURL + "/db"
+	if key != "" {
+		target += "/" + url.PathEscape(key)
+	}
+	// A conditional write may be applied even if its response is lost,
+	// repeating it would then fail with ErrVersionMismatch
+	conditional := header.Get("If-Match") != ""
+
+	backoff := c.backoff
+	for attempt := 0; ; attempt++ {
+		resp, err := c.attempt(ctx, method, target, key, body, header)
+		var statusErr *StatusError
+		retryable := err != nil && (!errors.As(err, &statusErr) || statusErr.StatusCode >= 500)
+		if conditional && !notSent(err) {
+			retryable = false
+		}
+		if !retryable || attempt >= c.retries || ctx.Err() != nil {
+			return resp, err
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil, err
+		}
+		backoff = min(2*backoff, maxBackoff)
+	}
+}
+
+// notSent tells whether the request failed before reaching the service.
+func notSent(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+func (c *Client) attempt(ctx context.Context, method, target, key string, body []byte, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, target, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if body != nil {
+		req.Header.Set("content-type", "application/json")
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 300 {
+		return resp, nil
+	}
+
+	defer resp.Body.Close()
+	message, _ := io.ReadAll(io.LimitReader(resp.Body, maxMessageLength))
+	return nil, &StatusError{
+		Method:     method,
+		Key:        key,
+		StatusCode: resp.StatusCode,
+		Message:    strings.TrimSpace(string(message)),
+	}
+}
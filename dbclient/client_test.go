@@ -0,0 +1,272 @@
+package dbclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeDb serves the key-value API from a map.
+func fakeDb() http.Handler {
+	var (
+		mu   sync.Mutex
+		data = map[string]string{}
+	)
+	h := http.NewServeMux()
+	h.HandleFunc("GET /db/{key}", func(rw http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		value, ok := data[r.PathValue("key")]
+		mu.Unlock()
+		if !ok {
+			http.Error(rw, "key not found", http.StatusNotFound)
+			return
+		}
+		_ = json.NewEncoder(rw).Encode(record{Key: r.PathValue("key"), Value: value})
+	})
+	h.HandleFunc("POST /db/{key}", func(rw http.ResponseWriter, r *http.Request) {
+		var body record
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		data[r.PathValue("key")] = body.Value
+		mu.Unlock()
+		rw.WriteHeader(http.StatusNoContent)
+	})
+	h.HandleFunc("DELETE /db/{key}", func(rw http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		delete(data, r.PathValue("key"))
+		mu.Unlock()
+		rw.WriteHeader(http.StatusNoContent)
+	})
+	h.HandleFunc("POST /db", func(rw http.ResponseWriter, r *http.Request) {
+		var operations []operation
+		if err := json.NewDecoder(r.Body).Decode(&operations); err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		for _, op := range operations {
+			if op.Delete {
+				delete(data, op.Key)
+			} else {
+				data[op.Key] = *op.Value
+			}
+		}
+		rw.WriteHeader(http.StatusNoContent)
+	})
+	return h
+}
+
+func TestClient(t *testing.T) {
+	server := httptest.NewServer(fakeDb())
+	defer server.Close()
+	client := New(server.URL)
+	ctx := context.Background()
+
+	if _, err := client.Get(ctx, "team"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+	if err := client.Put(ctx, "team", "2024-05-01"); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := client.Get(ctx, "team"); err != nil || value != "2024-05-01" {
+		t.Errorf("Bad value: %s, %v", value, err)
+	}
+	// Keys are escaped in the path
+	if err := client.Put(ctx, "a/b c", "value"); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := client.Get(ctx, "a/b c"); err != nil || value != "value" {
+		t.Errorf("Bad value for key with special characters: %s, %v", value, err)
+	}
+
+	if err := client.Delete(ctx, "team"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Get(ctx, "team"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for deleted key, got %v", err)
+	}
+
+	var b Batch
+	b.Put("x", "1")
+	b.Put("y", "2")
+	b.Delete("x")
+	if err := client.Batch(ctx, &b); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Get(ctx, "x"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for key deleted in batch, got %v", err)
+	}
+	if value, err := client.Get(ctx, "y"); err != nil || value != "2" {
+		t.Errorf("Bad value written in batch: %s, %v", value, err)
+	}
+}
+
+func TestClient_Errors(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		switch r.URL.Path {
+		case "/db/missing":
+			http.Error(rw, "key not found", http.StatusNotFound)
+		case "/db/typed":
+			http.Error(rw, "type mismatch", http.StatusConflict)
+		case "/db/flaky":
+			if calls.Load() < 3 {
+				http.Error(rw, "unavailable", http.StatusServiceUnavailable)
+				return
+			}
+			_ = json.NewEncoder(rw).Encode(record{Key: "flaky", Value: "ok"})
+		default:
+			http.Error(rw, "failure", http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+	client := New(server.URL, WithRetries(2, time.Millisecond))
+	ctx := context.Background()
+
+	check := func(key string, expectedCalls int32, match func(err error) bool) {
+		t.Helper()
+		calls.Store(0)
+		_, err := client.Get(ctx, key)
+		if !match(err) {
+			t.Errorf("Unexpected error for %s: %v", key, err)
+		}
+		if calls.Load() != expectedCalls {
+			t.Errorf("Expected %d requests for %s, got %d", expectedCalls, key, calls.Load())
+		}
+	}
+	check("missing", 1, func(err error) bool { return errors.Is(err, ErrNotFound) })
+	check("typed", 1, func(err error) bool { return errors.Is(err, ErrTypeMismatch) })
+	check("flaky", 3, func(err error) bool { return err == nil })
+	check("broken", 3, func(err error) bool {
+		var statusErr *StatusError
+		return errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusInternalServerError
+	})
+
+	// Retries stop once the context is done
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	calls.Store(0)
+	if _, err := client.Get(cancelled, "broken"); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	if calls.Load() != 0 {
+		t.Errorf("Expected no requests with cancelled context, got %d", calls.Load())
+	}
+
+	// Connection errors are retried too
+	server.Close()
+	start := time.Now()
+	if _, err := New(server.URL, WithRetries(2, 20*time.Millisecond)).Get(ctx, "key"); err == nil {
+		t.Error("Expected error for closed server")
+	}
+	if elapsed := time.Since(start); elapsed < 60*time.Millisecond {
+		t.Errorf("Expected retries with backoff, finished in %s", elapsed)
+	}
+}
+
+func TestClient_ConditionalRetries(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if r.URL.Path == "/db/lost" {
+			// The write is applied but the response never arrives
+			conn, _, err := rw.(http.Hijacker).Hijack()
+			if err == nil {
+				conn.Close()
+			}
+			return
+		}
+		http.Error(rw, "failure", http.StatusInternalServerError)
+	}))
+	defer server.Close()
+	client := New(server.URL, WithRetries(2, time.Millisecond))
+	ctx := context.Background()
+
+	for _, key := range []string{"lost", "broken"} {
+		calls.Store(0)
+		err := client.PutIf(ctx, key, "value", 1)
+		if err == nil || errors.Is(err, ErrVersionMismatch) {
+			t.Errorf("Unexpected error for %s: %v", key, err)
+		}
+		if calls.Load() != 1 {
+			t.Errorf("Expected a single conditional request for %s, got %d", key, calls.Load())
+		}
+	}
+	calls.Store(0)
+	if err := client.Put(ctx, "lost", "value"); err == nil {
+		t.Error("Expected error for lost response")
+	}
+	if calls.Load() != 3 {
+		t.Errorf("Expected unconditional write to be retried, got %d requests", calls.Load())
+	}
+
+	// Requests that could not be sent are safe to repeat
+	server.Close()
+	start := time.Now()
+	if err := New(server.URL, WithRetries(2, 20*time.Millisecond)).DeleteIf(ctx, "key", 1); err == nil {
+		t.Error("Expected error for closed server")
+	}
+	if elapsed := time.Since(start); elapsed < 60*time.Millisecond {
+		t.Errorf("Expected retries with backoff, finished in %s", elapsed)
+	}
+}
+
+func TestClient_Versions(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		value   = "v1"
+		version = 1
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if ifMatch := r.Header.Get("if-match"); ifMatch != "" && ifMatch != `"`+strconv.Itoa(version)+`"` {
+			http.Error(rw, "version mismatch", http.StatusPreconditionFailed)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			rw.Header().Set("etag", `"`+strconv.Itoa(version)+`"`)
+			_ = json.NewEncoder(rw).Encode(record{Key: "key", Value: value})
+		case http.MethodPost:
+			var body record
+			_ = json.NewDecoder(r.Body).Decode(&body)
+			value, version = body.Value, version+1
+			rw.WriteHeader(http.StatusNoContent)
+		case http.MethodDelete:
+			rw.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer server.Close()
+	client := New(server.URL)
+	ctx := context.Background()
+
+	got, v, err := client.GetWithVersion(ctx, "key")
+	if err != nil || got != "v1" || v != 1 {
+		t.Fatalf("Bad value: %s, %d, %v", got, v, err)
+	}
+	if err := client.PutIf(ctx, "key", "v2", v); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.PutIf(ctx, "key", "stale", v); !errors.Is(err, ErrVersionMismatch) {
+		t.Errorf("Expected ErrVersionMismatch, got %v", err)
+	}
+	if err := client.DeleteIf(ctx, "key", v); !errors.Is(err, ErrVersionMismatch) {
+		t.Errorf("Expected ErrVersionMismatch deleting, got %v", err)
+	}
+	if err := client.DeleteIf(ctx, "key", v+1); err != nil {
+		t.Errorf("Unexpected error deleting: %v", err)
+	}
+}
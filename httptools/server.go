@@ -1,36 +1,76 @@
 package httptools
 
 import (
-    "fmt"
-    "log"
-    "net/http"
-    "time"
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
 )
 
 type Server interface {
-    Start()
+	Start()
+	// Shutdown stops accepting connections and waits for active requests.
+	Shutdown(ctx context.Context) error
 }
 
 type server struct {
-    httpServer *http.Server
+	httpServer *http.Server
+	logger     *slog.Logger
+	host       string
+}
+
+// ServerOption configures the server created with CreateServer.
+type ServerOption func(s *server)
+
+// WithLogger sets the logger for server events and HTTP errors.
+func WithLogger(logger *slog.Logger) ServerOption {
+	return func(s *server) {
+		s.logger = logger
+	}
+}
+
+// WithHost makes the server listen on the given host only instead of all interfaces.
+func WithHost(host string) ServerOption {
+	return func(s *server) {
+		s.host = host
+	}
 }
 
 func (s server) Start() {
-    go func() {
-        log.Println("Starting the HTTP server...")
-        err := s.httpServer.ListenAndServe()
-        log.Fatalf("HTTP server finished: %s. Finishing the process.", err)
-    }()
+	go func() {
+		s.logger.Info("Starting the HTTP server", "addr", s.httpServer.Addr)
+		err := s.httpServer.ListenAndServe()
+		if errors.Is(err, http.ErrServerClosed) {
+			return
+		}
+		s.logger.Error("HTTP server finished, finishing the process", "err", err)
+		os.Exit(1)
+	}()
+}
+
+func (s server) Shutdown(ctx context.Context) error {
+	s.logger.Info("Stopping the HTTP server", "addr", s.httpServer.Addr)
+	return s.httpServer.Shutdown(ctx)
 }
 
-func CreateServer(port int, handler http.Handler) Server {
-    return server{
-        httpServer: &http.Server{
-            Addr:           fmt.Sprintf(":%d", port),
-            Handler:        handler,
-            ReadTimeout:    10 * time.Second,
-            WriteTimeout:   10 * time.Second,
-            MaxHeaderBytes: 1 << 20,
-        },
-    }
+func CreateServer(port int, handler http.Handler, opts ...ServerOption) Server {
+	s := server{
+		httpServer: &http.Server{
+			Handler:        handler,
+			ReadTimeout:    10 * time.Second,
+			WriteTimeout:   10 * time.Second,
+			MaxHeaderBytes: 1 << 20,
+		},
+		logger: slog.Default(),
+	}
+	for _, opt := range opts {
+		opt(&s)
+	}
+	s.httpServer.Addr = net.JoinHostPort(s.host, strconv.Itoa(port))
+	s.httpServer.ErrorLog = slog.NewLogLogger(s.logger.Handler(), slog.LevelError)
+	return s
 }
@@ -53,3 +53,13 @@ func TestServer_Start(t *testing.T) {
 		t.Fatalf("Expected status code %d, but got %d", http.StatusOK, resp.StatusCode)
 	}
 }
+
+func TestCreateServer_WithHost(t *testing.T) {
+	handler := http.NotFoundHandler()
+	if addr := CreateServer(8095, handler).(server).httpServer.Addr; addr != ":8095" {
+		t.Errorf("Expected all interfaces by default, got %s", addr)
+	}
+	if addr := CreateServer(8095, handler, WithHost("127.0.0.1")).(server).httpServer.Addr; addr != "127.0.0.1:8095" {
+		t.Errorf("Expected 127.0.0.1:8095, got %s", addr)
+	}
+}
@@ -1,6 +1,7 @@
 package integration
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"os"
@@ -8,7 +9,10 @@ import (
 	"time"
 )
 
-const baseAddress = "http://balancer:8090"
+const (
+	baseAddress = "http://balancer:8090"
+	teamKey     = "lb5" // Record seeded by the servers on startup
+)
 
 var client = http.Client{
 	Timeout: 3 * time.Second,
@@ -19,7 +23,7 @@ func TestBalancer(t *testing.T) {
 		t.Skip("Integration test is not enabled")
 	}
 
-	url := fmt.Sprintf("%s/api/v1/some-data", baseAddress)
+	url := fmt.Sprintf("%s/api/v1/some-data?key=%s", baseAddress, teamKey)
 	resp, err := client.Get(url)
 	if err != nil {
 		t.Fatalf("Failed to get response: %v", err)
@@ -35,6 +39,17 @@ func TestBalancer(t *testing.T) {
 		t.Fatalf("Expected lb-from header, but got empty")
 	}
 	t.Logf("response from [%s]", lbFrom)
+
+	var body struct {
+		Key   string `json:"key"`
+		Value string `json:"value"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if body.Key != teamKey || body.Value == "" {
+		t.Errorf("Unexpected response %+v", body)
+	}
 }
 
 func BenchmarkBalancer(b *testing.B) {
@@ -42,7 +57,7 @@ func BenchmarkBalancer(b *testing.B) {
 		b.Skip("Integration test is not enabled")
 	}
 
-	url := fmt.Sprintf("%s/api/v1/some-data", baseAddress)
+	url := fmt.Sprintf("%s/api/v1/some-data?key=%s", baseAddress, teamKey)
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		resp, err := client.Get(url)
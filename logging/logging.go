@@ -0,0 +1,42 @@
+// Package logging sets up structured leveled logs shared by all the components.
+package logging
+
+import (
+	"flag"
+	"io"
+	"log/slog"
+	"os"
+)
+
+// Config describes how log records are written.
+type Config struct {
+	Level  slog.Level
+	JSON   bool      // Write records as JSON instead of key=value text
+	Output io.Writer // Defaults to stderr
+}
+
+// BindFlags registers -log-level and -log-json flags filling the returned config.
+func BindFlags(fs *flag.FlagSet) *Config {
+	cfg := new(Config)
+	fs.TextVar(&cfg.Level, "log-level", slog.LevelInfo, "minimal level of logged records (debug, info, warn, error)")
+	fs.BoolVar(&cfg.JSON, "log-json", false, "whether to write logs as JSON")
+	return cfg
+}
+
+// New creates a logger according to the config.
+func New(cfg Config) *slog.Logger {
+	out := cfg.Output
+	if out == nil {
+		out = os.Stderr
+	}
+	opts := &slog.HandlerOptions{Level: cfg.Level}
+	if cfg.JSON {
+		return slog.New(slog.NewJSONHandler(out, opts))
+	}
+	return slog.New(slog.NewTextHandler(out, opts))
+}
+
+// Component returns a logger marking all its records with the component name.
+func Component(logger *slog.Logger, name string) *slog.Logger {
+	return logger.With("component", name)
+}
@@ -0,0 +1,41 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestNew(t *testing.T) {
+	var out bytes.Buffer
+	logger := Component(New(Config{Level: slog.LevelWarn, JSON: true, Output: &out}), "test")
+
+	logger.Info("hidden")
+	logger.Warn("shown", "key", "value")
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("Expected a single record, got %q", out.String())
+	}
+	var record map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &record); err != nil {
+		t.Fatal(err)
+	}
+	if record["msg"] != "shown" || record["component"] != "test" || record["key"] != "value" {
+		t.Errorf("Unexpected record %v", record)
+	}
+}
+
+func TestBindFlags(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	cfg := BindFlags(fs)
+	if err := fs.Parse([]string{"-log-level", "debug", "-log-json"}); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Level != slog.LevelDebug || !cfg.JSON {
+		t.Errorf("Unexpected config %+v", cfg)
+	}
+}
@@ -1,7 +1,7 @@
 package signal
 
 import (
-	"log"
+	"log/slog"
 	"os"
 	"os/signal"
 	"syscall"
@@ -12,5 +12,5 @@ func WaitForTerminationSignal() {
 	intChannel := make(chan os.Signal, 1)
 	signal.Notify(intChannel, syscall.SIGINT, syscall.SIGTERM)
 	<-intChannel
-	log.Println("Shutting down...")
+	slog.Info("Shutting down")
 }